@@ -0,0 +1,106 @@
+package main
+
+import (
+	"filippo.io/age"
+	"flag"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/tarpipe"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	ExtractCommand = "extract"
+	AgeExtension   = ".age"
+)
+
+// recipientsFor returns the age recipients from entry[recipientKey]. The value is a string or list of strings.
+func recipientsFor(entry interface{}) ([]age.Recipient, error) {
+	m, ok := entry.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no config entry for recipient '%s'", recipientKey)
+	}
+	var keys []string
+	switch v := m[recipientKey].(type) {
+	case string:
+		keys = []string{v}
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("recipient '%s' not found", recipientKey)
+	}
+	return age.ParseRecipients(strings.NewReader(strings.Join(keys, "\n")))
+}
+
+// encryptOutput returns a writer that encrypts to the recipients of entry and writes to w.
+func encryptOutput(w io.Writer, entry interface{}) (io.WriteCloser, error) {
+	recipients, err := recipientsFor(entry)
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// extractMain decrypts and extracts archives: cfgtar extract [-age-key <keys.txt>]... [-C <dir>] [<file.tar.age>...]
+func extractMain(args []string) {
+	var keyFiles stringList
+	var dir string
+	fs := flag.NewFlagSet(ExtractCommand, flag.ExitOnError)
+	fs.Var(&keyFiles, "age-key", "age identity file (repeatable, default $"+EnvAgeKeyFile+")")
+	fs.StringVar(&dir, "C", ".", "Target directory")
+	_ = fs.Parse(args)
+	if len(keyFiles) == 0 && os.Getenv(EnvAgeKeyFile) != "" {
+		keyFiles = stringList{os.Getenv(EnvAgeKeyFile)}
+	}
+	if len(keyFiles) == 0 {
+		printError(1, "%s %s -age-key <keys.txt> [-C <dir>] [<file.tar.age>...]", os.Args[0], ExtractCommand)
+	}
+	var ids []age.Identity
+	for _, fn := range keyFiles {
+		f, err := os.Open(fn)
+		if err != nil {
+			printError(2, "%s\n", err)
+		}
+		id, err := age.ParseIdentities(f)
+		_ = f.Close()
+		if err != nil {
+			printError(2, "%s: %s\n", fn, err)
+		}
+		ids = append(ids, id...)
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, fn := range files {
+		input := os.Stdin
+		if fn != "-" {
+			var err error
+			if input, err = os.Open(fn); err != nil {
+				printError(5, "%s\n", err)
+			}
+		}
+		r, err := age.Decrypt(input, ids...)
+		if err != nil {
+			printError(6, "%s: %s\n", fn, err)
+		}
+		if err := tarpipe.Extract(r, dir); err != nil {
+			printError(6, "%s: %s\n", fn, err)
+		}
+		_ = input.Close()
+	}
+}
@@ -1,22 +1,32 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgfile"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"github.com/JonathanLogan/cfgtar/pkg/interpolate"
 	"github.com/JonathanLogan/cfgtar/pkg/jsonschema"
+	"github.com/JonathanLogan/cfgtar/pkg/redact"
 	"github.com/JonathanLogan/cfgtar/pkg/schemareg"
+	"github.com/JonathanLogan/cfgtar/pkg/sops"
 	"github.com/JonathanLogan/cfgtar/pkg/tarpipe"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // cat template.tar | cfgtar config | tar -x -C /
 const (
 	SchemaFileName = "._config-schema.json"
+	EnvPrefix      = "CFGTAR_SET_"
+	EnvPathSep     = "__"
+	EnvAgeKeyFile  = "SOPS_AGE_KEY_FILE"
 )
 
 // SELECTOR SUPPORT: -s KEY. Iterate over config[KEY] and produce output to KEY.tar
@@ -24,30 +34,54 @@ const (
 var (
 	flagDryRun      bool
 	flagValidateRun bool
+	flagStrict      bool
 	inputFile       string
 	inputFd         *os.File
 	outputFd        *os.File
 	configFile      string
+	configLayers    stringList
+	setValues       stringList
+	setJSONValues   stringList
+	ageKeyFiles     stringList
+	pgpKeyFiles     stringList
 	schemaFile      string
+	configFormat    string
 	delim           string
 	delimLeft       string
 	delimRight      string
 	schemaFileName  string
 	configData      interface{}
+	configPositions = make(cfgfile.Positions)
+	configBranches  map[string]string
+	configSecrets   []string
 	schemaData      interface{}
 	selector        string
 	target          string
 	selectorData    []string
+	recipientKey    string
+	secretReport    string
+	secretMode      string
+	secretFiles     = make(map[string]bool)
 )
 
 func init() {
 	flag.BoolVar(&flagDryRun, "d", false, "dry run (no output)")
 	flag.BoolVar(&flagValidateRun, "v", false, "validate before generating output, requires input file")
 	flag.StringVar(&inputFile, "i", "", "Input tarfile")
+	flag.BoolVar(&flagStrict, "strict", false, "Reject config keys not defined by the schema (default: warn)")
 	flag.StringVar(&delim, "D", "{{.}}", "Left|Right delimiter")
 	flag.StringVar(&schemaFileName, "S", SchemaFileName, "Name of embedded schema file")
 	flag.StringVar(&selector, "s", "", "Selector: Iterate over config.selector and write to selector.tar(s)")
 	flag.StringVar(&target, "t", "", "Target directory for selector runs")
+	flag.Var(&configLayers, "c", "Config layer, deep-merged in order before config.json (repeatable)")
+	flag.Var(&setValues, "set", "Set config value: path=value, typed by schema (repeatable)")
+	flag.Var(&setJSONValues, "set-json", "Set config value: path=json (repeatable)")
+	flag.StringVar(&recipientKey, "e", "", "Encrypt output to the age recipient(s) in this key of the target's config entry")
+	flag.StringVar(&secretReport, "secret-report", "", "Write names of files that contain secret values to this file")
+	flag.StringVar(&secretMode, "secret-mode", "", "Set mode of files that contain secret values, e.g. 0600")
+	flag.Var(&ageKeyFiles, "age-key", "age identity file to decrypt SOPS files (repeatable, default $"+EnvAgeKeyFile+")")
+	flag.Var(&pgpKeyFiles, "pgp-key", "PGP secret keyring to decrypt SOPS files (repeatable)")
+	flag.StringVar(&configFormat, "f", "", "Format of config files: json, yaml, toml, hcl, kv (default: by file extension)")
 }
 
 func params() {
@@ -58,25 +92,52 @@ func params() {
 	}
 	args := flag.Args()
 	switch len(args) {
+	case 0:
+		if len(configLayers) == 0 {
+			printError(1, "%s [-c <layer.json>]... [<schema.json>] <config.json>", os.Args[0])
+		}
 	case 1:
 		configFile = args[0]
 	case 2:
 		configFile = args[1]
 		schemaFile = args[0]
 	default:
-		printError(1, "%s [<schema.json>] <config.json>", os.Args[0])
+		printError(1, "%s [-c <layer.json>]... [<schema.json>] <config.json>", os.Args[0])
 	}
-	if configData, err = parseJsonFile(configFile); err != nil {
-		printError(2, "%s: %s\n", configFile, err)
+	if err = loadKeys(); err != nil {
+		printError(2, "%s\n", err)
 	}
 	if schemaFile != "" {
-		if schemaData, err = parseJsonFile(schemaFile); err != nil {
+		if schemaData, err = parseSchemaFile(schemaFile); err != nil {
 			printError(3, "%s: %s\n", schemaFile, err)
 		}
-		errPath, _, err := jsonschema.Validate(schemaData, configData)
-		if err != nil {
-			printError(4, "Schema validation: %v %s\n", errPath, err)
+	}
+	if configData, err = loadConfig(); err != nil {
+		printError(2, "%s\n", err)
+	}
+	if err = applyOverrides(); err != nil {
+		printError(2, "%s\n", err)
+	}
+	if configData, err = interpolate.Resolve(configData); err != nil {
+		var refErr *interpolate.Error
+		if errors.As(err, &refErr) {
+			printError(2, "%s%s\n", positionPrefix(cfgfile.Pointer(refErr.Path...)), err)
 		}
+		printError(2, "%s\n", err)
+	}
+	if schemaData != nil {
+		result := jsonschema.ValidateAll(schemaData, configData, append(schemaOptions(), jsonschema.Strict(flagStrict))...)
+		redact.Add(result.Secrets...)
+		if len(result.Warnings) > 0 {
+			printWarning("Schema warning:\n%s\n", violationsString(result.Warnings))
+		}
+		if len(result.Violations) > 0 {
+			printError(4, "Schema validation:\n%s\n", violationsString(result.Violations))
+		}
+		// Templates use the config data as written, with the defaults of the schema.
+		configData = result.WithDefaults(configData)
+		configBranches = result.Branches
+		configSecrets = result.SecretPointers
 	}
 	if inputFile != "" {
 		if inputFd, err = os.Open(inputFile); err != nil {
@@ -94,6 +155,11 @@ func params() {
 		delimLeft = d[0]
 		delimRight = d[1]
 	}
+	if secretMode != "" {
+		if _, err := strconv.ParseUint(secretMode, 8, 32); err != nil {
+			printError(5, "Invalid mode '%s'", secretMode)
+		}
+	}
 	findSelector()
 }
 
@@ -132,28 +198,211 @@ func setSelector(pos int, s string) {
 	}
 }
 
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func printError(exitCode int, format string, v ...interface{}) {
-	_, _ = fmt.Fprintf(os.Stderr, format+"\n", v...)
+	_, _ = fmt.Fprint(os.Stderr, redact.String(fmt.Sprintf(format+"\n", v...)))
 	os.Exit(exitCode)
 }
 
-func parseJsonFile(filename string) (interface{}, error) {
-	var ret interface{}
-	d, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
+func printWarning(format string, v ...interface{}) {
+	_, _ = fmt.Fprint(os.Stderr, redact.String(fmt.Sprintf(format, v...)))
+}
+
+// parseSchemaFile reads a schema file. The format is determined by the file extension, -f applies to config files
+// only.
+func parseSchemaFile(filename string) (interface{}, error) {
+	return cfgfile.ParseFile(filename, "")
+}
+
+// schemaOptions returns the options to validate with the schema file, which load the schema files named types refer
+// to ("file.json#type").
+func schemaOptions() []jsonschema.Option {
+	return []jsonschema.Option{jsonschema.Loader(parseSchemaFile), jsonschema.SchemaFile(schemaFile)}
+}
+
+// loadKeys loads the keys to decrypt SOPS encrypted config files.
+func loadKeys() error {
+	keys := new(sops.Keys)
+	if len(ageKeyFiles) == 0 && os.Getenv(EnvAgeKeyFile) != "" {
+		ageKeyFiles = stringList{os.Getenv(EnvAgeKeyFile)}
 	}
-	if err := json.Unmarshal(d, &ret); err != nil {
-		return nil, err
+	for _, fn := range ageKeyFiles {
+		if err := keys.LoadAgeKeys(fn); err != nil {
+			return err
+		}
+	}
+	for _, fn := range pgpKeyFiles {
+		if err := keys.LoadPGPKeys(fn); err != nil {
+			return err
+		}
+	}
+	cfgfile.SetDecrypter(keys.Decrypt)
+	return nil
+}
+
+// loadConfig reads all config layers and the config file and merges them in order.
+func loadConfig() (interface{}, error) {
+	files := configLayers
+	if configFile != "" {
+		files = append(files, configFile)
+	}
+	layers := make([]interface{}, 0, len(files))
+	for _, fn := range files {
+		d, positions, err := cfgfile.LoadFilePositions(fn, configFormat)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fn, err)
+		}
+		layers = append(layers, d)
+		configPositions.Merge("", positions)
+	}
+	return cfgmerge.MergeAll(layers...)
+}
+
+// positionPrefix returns "file:line:col: " for the config value at pointer, or "" if its source is not known.
+func positionPrefix(pointer string) string {
+	if pos, ok := configPositions.Lookup(pointer); ok {
+		return pos.String() + ": "
+	}
+	return ""
+}
+
+// violationsString formats violations one per line, prefixed by the source position of the value.
+func violationsString(violations jsonschema.Violations) string {
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = positionPrefix(v.Pointer()) + v.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// applyOverrides sets config values from the environment and from -set and -set-json, in that order.
+func applyOverrides() error {
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, EnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(e[len(EnvPrefix):], "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		if err := setValue(strings.Split(kv[0], EnvPathSep), kv[1], false, EnvPrefix+kv[0]); err != nil {
+			return fmt.Errorf("%s: %s", e, err)
+		}
+	}
+	for _, l := range []struct {
+		values stringList
+		isJSON bool
+		flag   string
+	}{{setValues, false, "-set"}, {setJSONValues, true, "-set-json"}} {
+		for _, e := range l.values {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%s: missing '='", e)
+			}
+			path, err := cfgmerge.ParsePath(kv[0])
+			if err != nil {
+				return err
+			}
+			if err := setValue(path, kv[1], l.isJSON, l.flag+" "+kv[0]); err != nil {
+				return fmt.Errorf("%s: %s", e, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setValue sets path in configData. Plain values are converted to the type the schema defines for path, if any
+// (numbers and booleans).
+// Source is reported as position of the value.
+func setValue(path []string, s string, isJSON bool, source string) error {
+	var value interface{} = s
+	if isJSON {
+		d, err := cfgfile.Decode("json", []byte(s))
+		if err != nil {
+			return err
+		}
+		value = d
+	} else if schemaData != nil {
+		if t, ok := jsonschema.TypeAt(schemaData, path, schemaOptions()...); ok {
+			switch t {
+			case "int", "float":
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return err
+				}
+				value = f
+			case "bool":
+				b, err := strconv.ParseBool(s)
+				if err != nil {
+					return err
+				}
+				value = b
+			}
+		}
+	}
+	var err error
+	configData, err = cfgmerge.Set(configData, path, value)
+	pointer := cfgfile.Pointer(path...)
+	configPositions.Delete(pointer)
+	configPositions[pointer] = cfgfile.Position{File: source}
+	return err
+}
+
+func tarConfig() *tarpipe.Config {
+	mode, _ := strconv.ParseInt(secretMode, 8, 64)
+	return &tarpipe.Config{
+		DelimLeft:      delimLeft,
+		DelimRight:     delimRight,
+		SchemaFileName: schemaFileName,
+		SecretFileMode: mode,
+		Branches:       configBranches,
+		SecretPointers: configSecrets,
+		Strict:         flagStrict,
+		Schema:         schemaData,
+		SchemaLoader:   parseSchemaFile,
+		SchemaFile:     schemaFile,
+		SecretFile: func(name string) {
+			secretFiles[name] = true
+		},
+		Warning: func(msg string) {
+			printWarning("Schema warning: %s\n", msg)
+		},
+	}
+}
+
+// writeSecretReport writes the names of all files that contain secret values, one per line.
+func writeSecretReport() {
+	if secretReport == "" {
+		return
+	}
+	names := make([]string, 0, len(secretFiles))
+	for name := range secretFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := strings.Join(names, "\n")
+	if len(names) > 0 {
+		report += "\n"
+	}
+	if err := ioutil.WriteFile(secretReport, []byte(report), 0644); err != nil {
+		printError(8, "%s\n", err)
 	}
-	return ret, nil
 }
 
 func dryRun() {
 	if err := tarpipe.TarPipe(inputFd, nil,
 		schemareg.New(configData),
-		delimLeft, delimRight,
-		schemaFileName); err != nil {
+		tarConfig()); err != nil {
 		printError(6, "%s\n", err)
 	}
 	if flagValidateRun {
@@ -172,12 +421,22 @@ func selectorRun() {
 	var err error
 	for k, v := range selectorData {
 		fn := path.Join(target, v) + ".tar"
+		var entry interface{}
+		if m, ok := configData.(map[string]interface{}); ok {
+			entry = m[v]
+		}
+		if recipientKey != "" {
+			fn += AgeExtension
+			if _, err := recipientsFor(entry); err != nil {
+				printError(6, "%s: %s\n", v, err)
+			}
+		}
 		outputFd, err = os.Create(fn)
 		if err != nil {
 			printError(6, "Cannot create target: %s %s\n", fn, err)
 		}
 		setSelector(k, v)
-		run()
+		run(entry)
 	}
 }
 
@@ -187,11 +446,22 @@ func inputReset() {
 	}
 }
 
-func run() {
-	if err := tarpipe.TarPipe(inputFd, outputFd,
+// run writes the output to outputFd. If encryption is enabled, the recipients are taken from entry.
+func run(entry interface{}) {
+	var output io.WriteCloser = nopCloser{outputFd}
+	if recipientKey != "" {
+		var err error
+		if output, err = encryptOutput(outputFd, entry); err != nil {
+			_ = outputFd.Close()
+			printError(6, "%s: %s\n", outputFd.Name(), err)
+		}
+	}
+	if err := tarpipe.TarPipe(inputFd, output,
 		schemareg.New(configData),
-		delimLeft, delimRight,
-		schemaFileName); err != nil {
+		tarConfig()); err != nil {
+		printError(20, "%s\n", err)
+	}
+	if err := output.Close(); err != nil {
 		printError(20, "%s\n", err)
 	}
 	_ = outputFd.Sync()
@@ -199,6 +469,10 @@ func run() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == ExtractCommand {
+		extractMain(os.Args[2:])
+		return
+	}
 	params()
 	if flagDryRun || flagValidateRun {
 		if len(selector) > 0 {
@@ -211,7 +485,8 @@ func main() {
 		if len(selector) > 0 {
 			selectorRun()
 		} else {
-			run()
+			run(configData)
 		}
 	}
+	writeSecretReport()
 }
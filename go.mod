@@ -3,3 +3,27 @@ module github.com/JonathanLogan/cfgtar
 go 1.18
 
 require github.com/akamensky/base58 v0.0.0-20210829145138-ce8bf8802e8f
+
+require golang.org/x/net v0.24.0
+
+require (
+	filippo.io/age v1.1.1
+	github.com/BurntSushi/toml v1.4.0
+	github.com/ProtonMail/go-crypto v1.0.0
+	github.com/hashicorp/hcl/v2 v2.20.1
+	github.com/zclconf/go-cty v1.13.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/agext/levenshtein v1.2.1 // indirect
+	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
+	github.com/apparentlymart/go-textseg/v15 v15.0.0 // indirect
+	github.com/cloudflare/circl v1.3.3 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
+	golang.org/x/crypto v0.22.0 // indirect
+	golang.org/x/mod v0.8.0 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/tools v0.6.0 // indirect
+)
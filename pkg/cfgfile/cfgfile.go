@@ -0,0 +1,75 @@
+package cfgfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// DecodeFunc decodes the content of a config file into a generic tree as produced by encoding/json.
+type DecodeFunc func(d []byte) (interface{}, error)
+
+// DecryptFunc decrypts decoded config data. Order returns the line and column of the value at a JSON pointer, zero if
+// unknown. It returns false if the data is not encrypted.
+type DecryptFunc func(d interface{}, order func(pointer string) (int, int)) (interface{}, bool, error)
+
+const (
+	DefaultFormat = "json"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown config file format")
+)
+
+var (
+	decryptFunc  DecryptFunc
+	decoderMap   = make(map[string]DecodeFunc)
+	extensionMap = make(map[string]string)
+)
+
+// RegisterFormat registers a decoder for format, selected for files with one of the given extensions.
+func RegisterFormat(format string, f DecodeFunc, extensions ...string) {
+	decoderMap[format] = f
+	for _, ext := range extensions {
+		extensionMap[strings.ToLower(ext)] = format
+	}
+}
+
+// SetDecrypter sets the function used by LoadFile to decrypt encrypted config files.
+func SetDecrypter(f DecryptFunc) {
+	decryptFunc = f
+}
+
+// Format returns the format of filename as determined by its extension.
+func Format(filename string) string {
+	if format, ok := extensionMap[strings.ToLower(path.Ext(filename))]; ok {
+		return format
+	}
+	return DefaultFormat
+}
+
+// Decode decodes d in the given format.
+func Decode(format string, d []byte) (interface{}, error) {
+	f, ok := decoderMap[strings.ToLower(format)]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	ret, err := f(d)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(ret)
+}
+
+// ParseFile reads and decodes filename. If format is empty, it is determined by the file extension.
+func ParseFile(filename, format string) (interface{}, error) {
+	if format == "" {
+		format = Format(filename)
+	}
+	d, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(format, d)
+}
@@ -0,0 +1,103 @@
+package cfgfile
+
+import (
+	"testing"
+)
+
+var tdYAML = `
+defaults: &defaults
+  port: 22
+  user: root
+hosts:
+  web:
+    <<: *defaults
+    port: 2222
+`
+
+func TestYAML(t *testing.T) {
+	d, err := Decode(Format("config.yml"), []byte(tdYAML))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	web := d.(map[string]interface{})["hosts"].(map[string]interface{})["web"].(map[string]interface{})
+	if web["port"] != float64(2222) {
+		t.Errorf("Merge key override failed: %v", web["port"])
+	}
+	if web["user"] != "root" {
+		t.Errorf("Merge key not resolved: %v", web["user"])
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if Format("x.YAML") != "yaml" {
+		t.Error("yaml extension not detected")
+	}
+	if Format("x") != DefaultFormat {
+		t.Error("Default format not returned")
+	}
+	if _, err := Decode("unknown", nil); err != ErrUnknownFormat {
+		t.Error("Unknown format accepted")
+	}
+}
+
+var tdTOML = `
+hostname = "web1"
+port = 8080
+
+[network.eth0]
+ipv4 = "10.0.0.2/24"
+
+[[disks]]
+dev = "/dev/sda1"
+`
+
+func TestTOML(t *testing.T) {
+	d, err := Decode("toml", []byte(tdTOML))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	m := d.(map[string]interface{})
+	if m["port"] != float64(8080) {
+		t.Errorf("Integer not normalized: %T", m["port"])
+	}
+	if m["network"].(map[string]interface{})["eth0"].(map[string]interface{})["ipv4"] != "10.0.0.2/24" {
+		t.Error("Table not decoded")
+	}
+	if m["disks"].([]interface{})[0].(map[string]interface{})["dev"] != "/dev/sda1" {
+		t.Error("Array of tables not decoded")
+	}
+}
+
+var tdHCL = `
+hostname = "web1"
+ports = [80, 443]
+network "eth0" {
+  ipv4 = "10.0.0.2/24"
+}
+disk {
+  dev = "/dev/sda1"
+}
+disk {
+  dev = "/dev/sda2"
+}
+`
+
+func TestHCL(t *testing.T) {
+	d, err := Decode("hcl", []byte(tdHCL))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	m := d.(map[string]interface{})
+	if m["ports"].([]interface{})[1] != float64(443) {
+		t.Error("List attribute not decoded")
+	}
+	if m["network"].(map[string]interface{})["eth0"].(map[string]interface{})["ipv4"] != "10.0.0.2/24" {
+		t.Error("Labeled block not decoded")
+	}
+	if len(m["disk"].([]interface{})) != 2 {
+		t.Error("Repeated blocks not decoded to list")
+	}
+	if _, err := Decode("hcl", []byte("a = \n")); err == nil {
+		t.Error("Syntax error not reported")
+	}
+}
@@ -0,0 +1,167 @@
+package cfgfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"strconv"
+	"strings"
+)
+
+// decodeHCL decodes HCL native syntax. Attributes become keys, blocks become nested maps keyed by block type
+// and labels. Repeated blocks with the same type and labels become a list.
+func decodeHCL(d []byte) (interface{}, error) {
+	file, diags := hclsyntax.ParseConfig(d, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, hclError(diags)
+	}
+	return hclBody(file.Body.(*hclsyntax.Body))
+}
+
+func hclError(diags hcl.Diagnostics) error {
+	for _, diag := range diags {
+		if diag.Severity != hcl.DiagError {
+			continue
+		}
+		if diag.Subject != nil {
+			return fmt.Errorf("line %d, column %d: %s; %s", diag.Subject.Start.Line, diag.Subject.Start.Column,
+				diag.Summary, diag.Detail)
+		}
+		return fmt.Errorf("%s; %s", diag.Summary, diag.Detail)
+	}
+	return diags
+}
+
+func hclBody(body *hclsyntax.Body) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	for name, attr := range body.Attributes {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, hclError(diags)
+		}
+		d, err := ctyjson.Marshal(value, value.Type())
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(d, &v); err != nil {
+			return nil, err
+		}
+		ret[name] = v
+	}
+	blocks := make(map[string][]interface{})
+	order := make([][]string, 0, len(body.Blocks))
+	for _, block := range body.Blocks {
+		v, err := hclBody(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		keys := append([]string{block.Type}, block.Labels...)
+		id := strings.Join(keys, "\x00")
+		if _, ok := blocks[id]; !ok {
+			order = append(order, keys)
+		}
+		blocks[id] = append(blocks[id], v)
+	}
+	for _, keys := range order {
+		var v interface{} = blocks[strings.Join(keys, "\x00")]
+		if l := v.([]interface{}); len(l) == 1 {
+			v = l[0]
+		}
+		m := ret
+		for _, k := range keys[:len(keys)-1] {
+			sub, ok := m[k].(map[string]interface{})
+			if !ok {
+				if _, exists := m[k]; exists {
+					return nil, fmt.Errorf("%s: block conflicts with attribute", strings.Join(keys, "."))
+				}
+				sub = make(map[string]interface{})
+				m[k] = sub
+			}
+			m = sub
+		}
+		if _, exists := m[keys[len(keys)-1]]; exists {
+			return nil, fmt.Errorf("%s: block conflicts with attribute", strings.Join(keys, "."))
+		}
+		m[keys[len(keys)-1]] = v
+	}
+	return ret, nil
+}
+
+// hclPositions decodes HCL like decodeHCL and returns the positions of attributes, blocks and the elements of object
+// and tuple expressions.
+func hclPositions(d []byte) (interface{}, Positions, error) {
+	file, diags := hclsyntax.ParseConfig(d, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, nil, hclError(diags)
+	}
+	body := file.Body.(*hclsyntax.Body)
+	ret, err := hclBody(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	positions := Positions{"": Position{Line: 1, Column: 1, object: true}}
+	hclBodyPositions(positions, body, "")
+	return ret, positions, nil
+}
+
+func hclPosition(pos hcl.Pos, object bool) Position {
+	return Position{Line: pos.Line, Column: pos.Column, object: object}
+}
+
+func hclBodyPositions(positions Positions, body *hclsyntax.Body, pointer string) {
+	for name, attr := range body.Attributes {
+		hclExprPositions(positions, attr.Expr, pointer+Pointer(name))
+	}
+	count := make(map[string]int)
+	for _, block := range body.Blocks {
+		count[strings.Join(append([]string{block.Type}, block.Labels...), "\x00")]++
+	}
+	index := make(map[string]int)
+	for _, block := range body.Blocks {
+		keys := append([]string{block.Type}, block.Labels...)
+		p := pointer
+		for _, k := range keys {
+			p += Pointer(k)
+			if _, exists := positions[p]; !exists {
+				positions[p] = hclPosition(block.TypeRange.Start, true)
+			}
+		}
+		id := strings.Join(keys, "\x00")
+		if count[id] > 1 {
+			p += "/" + strconv.Itoa(index[id])
+			index[id]++
+		}
+		positions[p] = hclPosition(block.DefRange().Start, true)
+		hclBodyPositions(positions, block.Body, p)
+	}
+}
+
+func hclExprPositions(positions Positions, expr hclsyntax.Expression, pointer string) {
+	switch e := expr.(type) {
+	case *hclsyntax.ObjectConsExpr:
+		positions[pointer] = hclPosition(e.SrcRange.Start, true)
+		for _, item := range e.Items {
+			key, diags := item.KeyExpr.Value(nil)
+			if diags.HasErrors() || !key.Type().Equals(cty.String) || !key.IsKnown() || key.IsNull() {
+				continue
+			}
+			hclExprPositions(positions, item.ValueExpr, pointer+Pointer(key.AsString()))
+		}
+	case *hclsyntax.TupleConsExpr:
+		positions[pointer] = hclPosition(e.SrcRange.Start, false)
+		for i, v := range e.Exprs {
+			hclExprPositions(positions, v, pointer+"/"+strconv.Itoa(i))
+		}
+	default:
+		positions[pointer] = hclPosition(expr.Range().Start, false)
+	}
+}
+
+func init() {
+	RegisterFormat("hcl", decodeHCL, ".hcl")
+	RegisterPositions("hcl", hclPositions)
+}
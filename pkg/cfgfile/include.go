@@ -0,0 +1,306 @@
+package cfgfile
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config files can include other files:
+//
+//	{"$include": "common.json", "key": "value"}: The included files (string or list of strings) are merged in
+//	order, keys of the including object are merged on top.
+//	{"$ref": "common.json#/ntp/servers"}: The object is replaced by the value at the JSON pointer in the referenced
+//	file. Without file name the pointer refers to the same file. The object cannot have other keys.
+//
+// File names are relative to the including file, the format is determined by the file extension.
+
+const (
+	KeyInclude = "$include"
+	KeyRef     = "$ref"
+)
+
+var (
+	ErrIncludeCycle = errors.New("include cycle")
+	ErrIncludeType  = errors.New("include must be string or list of strings")
+	ErrRefType      = errors.New("reference must be string")
+	ErrRefKeys      = errors.New("reference cannot have other keys")
+	ErrPointer      = errors.New("JSON pointer not found")
+)
+
+type document struct {
+	data      interface{}
+	positions Positions
+}
+
+type loader struct {
+	stack []string
+	docs  map[string]*document
+}
+
+// LoadFile reads filename, decrypts it if a decrypter is set and resolves includes and references. If format is
+// empty, it is determined by the file extension.
+func LoadFile(filename, format string) (interface{}, error) {
+	ret, _, err := LoadFilePositions(filename, format)
+	return ret, err
+}
+
+// LoadFilePositions is like LoadFile and also returns the source positions of the values. Values taken from
+// included and referenced files are reported at their position in those files.
+func LoadFilePositions(filename, format string) (interface{}, Positions, error) {
+	l := &loader{
+		docs: make(map[string]*document),
+	}
+	return l.load(filename, format)
+}
+
+func (l *loader) push(id string) error {
+	for _, e := range l.stack {
+		if e == id {
+			return fmt.Errorf("%s: %w", id, ErrIncludeCycle)
+		}
+	}
+	l.stack = append(l.stack, id)
+	return nil
+}
+
+func (l *loader) pop() {
+	l.stack = l.stack[:len(l.stack)-1]
+}
+
+func (l *loader) read(filename, format string) (*document, error) {
+	if format == "" {
+		format = Format(filename)
+	}
+	d, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(document)
+	if doc.data, doc.positions, err = DecodePositions(format, d); err != nil {
+		return nil, err
+	}
+	doc.positions.setFile(filename)
+	if decryptFunc != nil {
+		order := func(pointer string) (int, int) {
+			pos := doc.positions[pointer]
+			return pos.Line, pos.Column
+		}
+		if doc.data, _, err = decryptFunc(doc.data, order); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (l *loader) load(filename, format string) (interface{}, Positions, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := l.push(abs); err != nil {
+		return nil, nil, err
+	}
+	defer l.pop()
+	doc, ok := l.docs[abs]
+	if !ok {
+		if doc, err = l.read(filename, format); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		l.docs[abs] = doc
+	}
+	ret, positions, err := l.resolve(filename, doc, doc.data, nil, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return ret, positions, nil
+}
+
+// resolve resolves includes and references in node. Pointer is the location of node in doc. The returned positions
+// are relative to node.
+func (l *loader) resolve(filename string, doc *document, node interface{}, path []string, pointer string) (interface{}, Positions, error) {
+	positions := make(Positions)
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := n[KeyRef]; ok {
+			if len(n) != 1 {
+				return nil, nil, fmt.Errorf("%s: %s %v: %w", pathString(path), KeyRef, ref, ErrRefKeys)
+			}
+			v, refPositions, err := l.ref(filename, doc, ref)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s %v: %w", pathString(path), KeyRef, ref, err)
+			}
+			return v, refPositions, nil
+		}
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			if k != KeyInclude {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		ret := make(map[string]interface{}, len(n))
+		for _, k := range keys {
+			v, p, err := l.resolve(filename, doc, n[k], append(path, k), pointer+Pointer(k))
+			if err != nil {
+				return nil, nil, err
+			}
+			ret[k] = v
+			positions.add(Pointer(k), p)
+		}
+		if pos, ok := doc.positions[pointer]; ok {
+			positions[""] = pos
+		}
+		include, ok := n[KeyInclude]
+		if !ok {
+			return ret, positions, nil
+		}
+		base, basePositions, err := l.include(filename, include)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s %v: %w", pathString(path), KeyInclude, include, err)
+		}
+		basePositions.Merge("", positions)
+		merged, err := cfgmerge.Merge(base, ret)
+		return merged, basePositions, err
+	case []interface{}:
+		ret := make([]interface{}, len(n))
+		for i, e := range n {
+			v, p, err := l.resolve(filename, doc, e, append(path, strconv.Itoa(i)), pointer+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, nil, err
+			}
+			ret[i] = v
+			positions.add("/"+strconv.Itoa(i), p)
+		}
+		if pos, ok := doc.positions[pointer]; ok {
+			positions[""] = pos
+		}
+		return ret, positions, nil
+	default:
+		if pos, ok := doc.positions[pointer]; ok {
+			positions[""] = pos
+		}
+		return n, positions, nil
+	}
+}
+
+func (l *loader) include(filename string, include interface{}) (interface{}, Positions, error) {
+	var files []string
+	switch i := include.(type) {
+	case string:
+		files = []string{i}
+	case []interface{}:
+		for _, e := range i {
+			s, ok := e.(string)
+			if !ok {
+				return nil, nil, ErrIncludeType
+			}
+			files = append(files, s)
+		}
+	default:
+		return nil, nil, ErrIncludeType
+	}
+	layers := make([]interface{}, 0, len(files))
+	positions := make(Positions)
+	for _, fn := range files {
+		d, p, err := l.load(relativePath(filename, fn), "")
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, d)
+		positions.Merge("", p)
+	}
+	ret, err := cfgmerge.MergeAll(layers...)
+	return ret, positions, err
+}
+
+func (l *loader) ref(filename string, doc *document, ref interface{}) (interface{}, Positions, error) {
+	s, ok := ref.(string)
+	if !ok {
+		return nil, nil, ErrRefType
+	}
+	fn, pointer := s, ""
+	if pos := strings.Index(s, "#"); pos >= 0 {
+		fn, pointer = s[:pos], s[pos+1:]
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fn != "" {
+		target, err := filepath.Abs(relativePath(filename, fn))
+		if err != nil {
+			return nil, nil, err
+		}
+		if target != abs {
+			d, positions, err := l.load(target, "")
+			if err != nil {
+				return nil, nil, err
+			}
+			v, err := lookupPointer(d, pointer)
+			if err != nil {
+				return nil, nil, err
+			}
+			return v, positions.sub(pointer), nil
+		}
+	}
+	// Reference into the same file, also if it is named.
+	if err := l.push(abs + "#" + pointer); err != nil {
+		return nil, nil, err
+	}
+	defer l.pop()
+	v, err := lookupPointer(doc.data, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.resolve(filename, doc, v, nil, pointer)
+}
+
+func relativePath(filename, include string) string {
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(filename), include)
+}
+
+// lookupPointer returns the value at JSON pointer (RFC 6901) in d.
+func lookupPointer(d interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return d, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%s: %w", pointer, ErrPointer)
+	}
+	for _, e := range strings.Split(pointer[1:], "/") {
+		e = strings.ReplaceAll(strings.ReplaceAll(e, "~1", "/"), "~0", "~")
+		switch n := d.(type) {
+		case map[string]interface{}:
+			v, ok := n[e]
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", pointer, ErrPointer)
+			}
+			d = v
+		case []interface{}:
+			i, err := strconv.Atoi(e)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("%s: %w", pointer, ErrPointer)
+			}
+			d = n[i]
+		default:
+			return nil, fmt.Errorf("%s: %w", pointer, ErrPointer)
+		}
+	}
+	return d, nil
+}
+
+func pathString(path []string) string {
+	if len(path) == 0 {
+		return "."
+	}
+	return strings.Join(path, ".")
+}
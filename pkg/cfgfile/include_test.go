@@ -0,0 +1,74 @@
+package cfgfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "cfgfile")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	for fn, content := range files {
+		p := filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"host.json":              `{"$include":"common/base.yaml","name":"host","ntp":{"$ref":"common/ntp.json#/servers"},"self":{"$ref":"#/name"},"own":{"$ref":"host.json#/name"}}`,
+		"common/base.yaml":       "$include: monitoring.json\nname: base\nzone: a\n",
+		"common/monitoring.json": `{"monitoring":{"interval":"10s"}}`,
+		"common/ntp.json":        `{"servers":["0.pool.ntp.org"]}`,
+	})
+	defer func() { _ = os.RemoveAll(dir) }()
+	d, err := LoadFile(filepath.Join(dir, "host.json"), "")
+	if err != nil {
+		t.Fatalf("LoadFile: %s", err)
+	}
+	expect := map[string]interface{}{
+		"name":       "host",
+		"zone":       "a",
+		"monitoring": map[string]interface{}{"interval": "10s"},
+		"ntp":        []interface{}{"0.pool.ntp.org"},
+		"self":       "host",
+		"own":        "host",
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Errorf("Wrong result: %v", d)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.json":    `{"$include":"b.json"}`,
+		"b.json":    `{"x":{"$include":"a.json"}}`,
+		"self.json": `{"a":{"$ref":"#/b"},"b":{"$ref":"#/a"}}`,
+		"ptr.json":  `{"a":{"$ref":"c.json#/y"}}`,
+		"c.json":    `{"x":1}`,
+		"keys.json": `{"a":{"$ref":"c.json#/x","y":2}}`,
+	})
+	defer func() { _ = os.RemoveAll(dir) }()
+	for fn, expect := range map[string]error{
+		"a.json":    ErrIncludeCycle,
+		"self.json": ErrIncludeCycle,
+		"ptr.json":  ErrPointer,
+		"keys.json": ErrRefKeys,
+	} {
+		if _, err := LoadFile(filepath.Join(dir, fn), ""); !errors.Is(err, expect) {
+			t.Errorf("%s: wrong error: %v", fn, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package cfgfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func decodeJSON(d []byte) (interface{}, error) {
+	var ret interface{}
+	if err := json.Unmarshal(d, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// jsonDecoder decodes JSON from the token stream and records the position of each value.
+type jsonDecoder struct {
+	dec       *json.Decoder
+	d         []byte
+	offset    int // Offset of line and col in d.
+	line, col int
+	positions Positions
+}
+
+func jsonPositions(d []byte) (interface{}, Positions, error) {
+	j := &jsonDecoder{dec: json.NewDecoder(bytes.NewReader(d)), d: d, line: 1, col: 1, positions: make(Positions)}
+	j.dec.UseNumber()
+	ret, err := j.value("")
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := j.dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid data after top-level value")
+		}
+		return nil, nil, err
+	}
+	return ret, j.positions, nil
+}
+
+// position returns the position of the next token.
+func (j *jsonDecoder) position() Position {
+	end := int(j.dec.InputOffset())
+	for end < len(j.d) && strings.IndexByte(" \t\r\n,:", j.d[end]) >= 0 {
+		end++
+	}
+	for j.offset < end {
+		r, size := utf8.DecodeRune(j.d[j.offset:])
+		if r == '\n' {
+			j.line, j.col = j.line+1, 1
+		} else {
+			j.col++
+		}
+		j.offset += size
+	}
+	return Position{Line: j.line, Column: j.col}
+}
+
+func (j *jsonDecoder) value(pointer string) (interface{}, error) {
+	pos := j.position()
+	token, err := j.dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			pos.object = true
+			j.positions[pointer] = pos
+			ret := make(map[string]interface{})
+			for j.dec.More() {
+				key, err := j.dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				k, _ := key.(string)
+				if ret[k], err = j.value(pointer + Pointer(k)); err != nil {
+					return nil, err
+				}
+			}
+			_, err := j.dec.Token()
+			return ret, err
+		}
+		j.positions[pointer] = pos
+		ret := make([]interface{}, 0)
+		for j.dec.More() {
+			v, err := j.value(pointer + "/" + strconv.Itoa(len(ret)))
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		_, err := j.dec.Token()
+		return ret, err
+	case json.Number:
+		j.positions[pointer] = pos
+		return t.Float64()
+	default:
+		j.positions[pointer] = pos
+		return t, nil
+	}
+}
+
+func init() {
+	RegisterFormat("json", decodeJSON, ".json")
+	RegisterPositions("json", jsonPositions)
+}
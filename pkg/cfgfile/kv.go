@@ -0,0 +1,305 @@
+package cfgfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"github.com/JonathanLogan/cfgtar/pkg/jsonschema"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The kv format consists of lines of the form:
+//
+//	key.sub (type)="value" # comment
+//	other.key=$key.sub
+//
+// Dotted keys build nested maps. The type annotation is optional and checked with the schema validator functions.
+// Values are quoted strings, numbers, true/false/null, references to other keys ($key) or bare strings.
+
+var (
+	ErrKVSyntax    = errors.New("syntax error")
+	ErrKVDuplicate = errors.New("key already defined")
+	ErrKVRefCycle  = errors.New("reference cycle")
+	ErrKVRefTarget = errors.New("reference target not found")
+)
+
+type kvRef struct {
+	path     []string
+	line     int
+	typeDef  string
+	visiting bool
+}
+
+type kvValue struct {
+	value   interface{}
+	raw     string
+	quoted  bool
+	typeDef string
+}
+
+func kvError(line int, err error) error {
+	return fmt.Errorf("line %d: %w", line, err)
+}
+
+func decodeKV(d []byte) (interface{}, error) {
+	ret, _, err := kvPositions(d)
+	return ret, err
+}
+
+// kvPositions decodes the kv format and returns the positions of the values. Objects created by dotted keys are
+// reported at their first key.
+func kvPositions(d []byte) (interface{}, Positions, error) {
+	ret := make(map[string]interface{})
+	positions := Positions{"": Position{Line: 1, Column: 1, object: true}}
+	scanner := bufio.NewScanner(bytes.NewReader(d))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		s := strings.TrimSpace(text)
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		key, value, err := parseKVLine(s, line)
+		if err != nil {
+			return nil, nil, kvError(line, err)
+		}
+		if err := kvSet(ret, key, value); err != nil {
+			return nil, nil, kvError(line, err)
+		}
+		column := strings.Index(text, "=") + 1
+		for column < len(text) && (text[column] == ' ' || text[column] == '\t') {
+			column++
+		}
+		pointer := ""
+		for _, k := range key[:len(key)-1] {
+			pointer += Pointer(k)
+			if _, exists := positions[pointer]; !exists {
+				positions[pointer] = Position{Line: line, Column: strings.Index(text, k) + 1, object: true}
+			}
+		}
+		positions[pointer+Pointer(key[len(key)-1])] = Position{Line: line, Column: utf8.RuneCountInString(text[:column]) + 1}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	r, err := kvResolve(ret, ret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, positions, nil
+}
+
+func parseKVLine(s string, line int) ([]string, interface{}, error) {
+	pos := strings.Index(s, "=")
+	if pos < 0 {
+		return nil, nil, fmt.Errorf("missing '=': %w", ErrKVSyntax)
+	}
+	keyDef, valueDef := strings.TrimSpace(s[:pos]), strings.TrimSpace(s[pos+1:])
+	var typeDef string
+	if p := strings.Index(keyDef, "("); p >= 0 {
+		if !strings.HasSuffix(keyDef, ")") {
+			return nil, nil, fmt.Errorf("unterminated type: %w", ErrKVSyntax)
+		}
+		typeDef = strings.TrimSpace(keyDef[p+1 : len(keyDef)-1])
+		keyDef = strings.TrimSpace(keyDef[:p])
+		if typeDef == "" {
+			return nil, nil, fmt.Errorf("empty type: %w", ErrKVSyntax)
+		}
+	}
+	key, err := kvKey(keyDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.HasPrefix(valueDef, "$") {
+		ref, err := kvKey(strings.TrimSpace(stripComment(valueDef[1:])))
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &kvRef{path: ref, line: line, typeDef: typeDef}, nil
+	}
+	v, err := parseKVValue(valueDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.typeDef = typeDef
+	if err := v.check(); err != nil {
+		return nil, nil, err
+	}
+	return key, v.value, nil
+}
+
+func kvKey(s string) ([]string, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty key: %w", ErrKVSyntax)
+	}
+	key := strings.Split(s, ".")
+	for _, k := range key {
+		if k == "" || strings.ContainsAny(k, " \t\"#$") {
+			return nil, fmt.Errorf("invalid key '%s': %w", s, ErrKVSyntax)
+		}
+	}
+	return key, nil
+}
+
+func stripComment(s string) string {
+	if pos := strings.Index(s, "#"); pos >= 0 {
+		return s[:pos]
+	}
+	return s
+}
+
+func parseKVValue(s string) (*kvValue, error) {
+	if strings.HasPrefix(s, "\"") {
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+				continue
+			}
+			if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return nil, fmt.Errorf("unterminated string: %w", ErrKVSyntax)
+		}
+		if rest := strings.TrimSpace(s[end+1:]); rest != "" && rest[0] != '#' {
+			return nil, fmt.Errorf("unexpected '%s' after string: %w", rest, ErrKVSyntax)
+		}
+		str, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", err, ErrKVSyntax)
+		}
+		return &kvValue{value: str, raw: str, quoted: true}, nil
+	}
+	raw := strings.TrimSpace(stripComment(s))
+	ret := &kvValue{value: raw, raw: raw}
+	switch raw {
+	case "true":
+		ret.value = true
+	case "false":
+		ret.value = false
+	case "null":
+		ret.value = nil
+	default:
+		if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			ret.value = f
+		}
+	}
+	return ret, nil
+}
+
+// check verifies the value against its type annotation. Unquoted literals that fail as parsed are retried as string.
+func (v *kvValue) check() error {
+	if v.typeDef == "" {
+		return nil
+	}
+	err := kvCheckType(v.typeDef, v.value)
+	if err != nil && !v.quoted {
+		if _, isStr := v.value.(string); !isStr {
+			if kvCheckType(v.typeDef, v.raw) == nil {
+				v.value = v.raw
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+func kvCheckType(typeDef string, value interface{}) error {
+	if _, _, err := jsonschema.Validate(typeDef, value); err != nil {
+		return fmt.Errorf("(%s): %w", typeDef, err)
+	}
+	return nil
+}
+
+func kvSet(m map[string]interface{}, key []string, value interface{}) error {
+	for i, k := range key[:len(key)-1] {
+		switch sub := m[k].(type) {
+		case nil:
+			if _, exists := m[k]; exists {
+				return fmt.Errorf("%s: %w", strings.Join(key[:i+1], "."), ErrKVDuplicate)
+			}
+			n := make(map[string]interface{})
+			m[k] = n
+			m = n
+		case map[string]interface{}:
+			m = sub
+		default:
+			return fmt.Errorf("%s: %w", strings.Join(key[:i+1], "."), ErrKVDuplicate)
+		}
+	}
+	last := key[len(key)-1]
+	if _, exists := m[last]; exists {
+		return fmt.Errorf("%s: %w", strings.Join(key, "."), ErrKVDuplicate)
+	}
+	m[last] = value
+	return nil
+}
+
+func kvLookup(root map[string]interface{}, path []string) (interface{}, bool) {
+	var cur interface{} = root
+	for _, k := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// kvResolve replaces all references in node with their values.
+func kvResolve(root map[string]interface{}, node interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case *kvRef:
+		if n.visiting {
+			return nil, kvError(n.line, fmt.Errorf("$%s: %w", strings.Join(n.path, "."), ErrKVRefCycle))
+		}
+		n.visiting = true
+		target, ok := kvLookup(root, n.path)
+		if !ok {
+			return nil, kvError(n.line, fmt.Errorf("$%s: %w", strings.Join(n.path, "."), ErrKVRefTarget))
+		}
+		v, err := kvResolve(root, target)
+		if err != nil {
+			return nil, err
+		}
+		n.visiting = false
+		if n.typeDef != "" {
+			if err := kvCheckType(n.typeDef, v); err != nil {
+				return nil, kvError(n.line, err)
+			}
+		}
+		return cfgmerge.Copy(v), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, err := kvResolve(root, n[k])
+			if err != nil {
+				return nil, err
+			}
+			n[k] = v
+		}
+		return n, nil
+	default:
+		return n, nil
+	}
+}
+
+func init() {
+	RegisterFormat("kv", decodeKV, ".data", ".kv")
+	RegisterPositions("kv", kvPositions)
+}
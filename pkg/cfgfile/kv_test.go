@@ -0,0 +1,69 @@
+package cfgfile
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var tdKV = `
+Name (string)="Complex # Config Name" # Comment
+variable.source (int)=2323
+# Some comment
+something.else (int)=$variable.source
+something.text (string)=2323
+something.copy=$variable
+`
+
+func TestKV(t *testing.T) {
+	d, err := Decode("kv", []byte(tdKV))
+	if err != nil {
+		t.Fatalf("Decode: %s", err)
+	}
+	m := d.(map[string]interface{})
+	if m["Name"] != "Complex # Config Name" {
+		t.Errorf("Quoted string wrong: %v", m["Name"])
+	}
+	something := m["something"].(map[string]interface{})
+	if something["else"] != float64(2323) {
+		t.Errorf("Reference not resolved: %v", something["else"])
+	}
+	if something["text"] != "2323" {
+		t.Errorf("Typed string not kept: %T", something["text"])
+	}
+	if something["copy"].(map[string]interface{})["source"] != float64(2323) {
+		t.Error("Map reference not resolved")
+	}
+	something["copy"].(map[string]interface{})["source"] = float64(1)
+	if m["variable"].(map[string]interface{})["source"] != float64(2323) {
+		t.Error("Map reference not copied")
+	}
+}
+
+func TestKVErrors(t *testing.T) {
+	td := []struct {
+		data string
+		line string
+		err  error
+	}{
+		{"a=1\nb (int)=x\n", "line 2:", nil},
+		{"a=1\na.b=2\n", "line 2:", ErrKVDuplicate},
+		{"a=$b\nb=$c\nc=$a\n", "line ", ErrKVRefCycle},
+		{"a=1\nb=$c\n", "line 2:", ErrKVRefTarget},
+		{"a\n", "line 1:", ErrKVSyntax},
+		{"a=\"open\n", "line 1:", ErrKVSyntax},
+	}
+	for i, e := range td {
+		_, err := Decode("kv", []byte(e.data))
+		if err == nil {
+			t.Errorf("%d: no error", i)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), e.line) {
+			t.Errorf("%d: wrong line: %s", i, err)
+		}
+		if e.err != nil && !errors.Is(err, e.err) {
+			t.Errorf("%d: wrong error: %s", i, err)
+		}
+	}
+}
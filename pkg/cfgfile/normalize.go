@@ -0,0 +1,72 @@
+package cfgfile
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrValueType = errors.New("unsupported value type")
+)
+
+// normalize converts decoded data into the shape produced by encoding/json: map[string]interface{},
+// []interface{}, string, float64, bool and nil.
+func normalize(d interface{}) (interface{}, error) {
+	switch v := d.(type) {
+	case nil, string, float64, bool:
+		return v, nil
+	case map[string]interface{}:
+		for k, e := range v {
+			n, err := normalize(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = n
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			n, err := normalize(e)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", k, err)
+			}
+			ret[fmt.Sprint(k)] = n
+		}
+		return ret, nil
+	case []interface{}:
+		for i, e := range v {
+			n, err := normalize(e)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			v[i] = n
+		}
+		return v, nil
+	case []map[string]interface{}:
+		ret := make([]interface{}, len(v))
+		for i, e := range v {
+			n, err := normalize(e)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			ret[i] = n
+		}
+		return ret, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return nil, fmt.Errorf("%T: %w", d, ErrValueType)
+	}
+}
@@ -0,0 +1,193 @@
+package cfgfile
+
+import (
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Position is the location of a value in a config file. Values that do not come from a file have only File set,
+// describing their source.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	object bool // The value is an object. Merging other positions keeps the positions of its members.
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Positions maps JSON pointers (RFC 6901) to the position of the value they refer to. The empty pointer is the
+// position of the document itself.
+type Positions map[string]Position
+
+// PositionFunc decodes d and returns the positions of all values, found by the same parse. File is not set.
+type PositionFunc func(d []byte) (interface{}, Positions, error)
+
+var positionMap = make(map[string]PositionFunc)
+
+// RegisterPositions registers the function that returns the positions of values in files of format.
+func RegisterPositions(format string, f PositionFunc) {
+	positionMap[format] = f
+}
+
+// DecodePositions decodes d like Decode and returns the positions of all values. Positions are empty if format does
+// not support them.
+func DecodePositions(format string, d []byte) (interface{}, Positions, error) {
+	f, ok := positionMap[strings.ToLower(format)]
+	if !ok {
+		ret, err := Decode(format, d)
+		return ret, make(Positions), err
+	}
+	ret, positions, err := f(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ret, err = normalize(ret); err != nil {
+		return nil, nil, err
+	}
+	return ret, positions, nil
+}
+
+// Pointer returns the JSON pointer for path.
+func Pointer(path ...string) string {
+	b := new(strings.Builder)
+	for _, e := range path {
+		b.WriteString("/")
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(e, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// Lookup returns the position of the value at pointer. If the value has no position, the position of the closest
+// parent is returned.
+func (p Positions) Lookup(pointer string) (Position, bool) {
+	for {
+		if pos, ok := p[pointer]; ok {
+			return pos, true
+		}
+		i := strings.LastIndex(pointer, "/")
+		if i < 0 {
+			return Position{}, false
+		}
+		pointer = pointer[:i]
+	}
+}
+
+// Delete removes the positions of the value at pointer and all values below it.
+func (p Positions) Delete(pointer string) {
+	for k := range p {
+		if k == pointer || strings.HasPrefix(k, pointer+"/") {
+			delete(p, k)
+		}
+	}
+}
+
+// Merge adds the positions of overlay below pointer. Like config layers, objects are merged and all other values
+// replace the previous value. Merge markers of the keys in overlay are applied: "%delete" removes the positions,
+// "%append" moves the positions of the items behind the items of the previous layers. Arrays merged by key
+// ("%merge=field") only keep the position of the array, the positions of their items are not known.
+func (p Positions) Merge(pointer string, overlay Positions) {
+	keys := make([]string, 0, len(overlay))
+	for k := range overlay {
+		keys = append(keys, k)
+	}
+	// Parents before children, so that replacing a parent does not remove the children of overlay.
+	sort.Strings(keys)
+	offsets := make(map[string]int) // Number of previous items of arrays appended to, by pointer.
+next:
+	for _, k := range keys {
+		target, mode := pointer, ""
+		elements := pointerElements(k)
+		for i, e := range elements {
+			name, marker, _ := cfgmerge.SplitMarker(e)
+			if offset, ok := offsets[target]; ok {
+				if n, err := strconv.Atoi(name); err == nil {
+					name = strconv.Itoa(n + offset)
+				}
+			}
+			target += Pointer(name)
+			last := i == len(elements)-1
+			switch marker {
+			case cfgmerge.MarkerDelete:
+				p.Delete(target)
+				continue next
+			case cfgmerge.MarkerMerge:
+				if !last {
+					continue next
+				}
+			case cfgmerge.MarkerAppend:
+				if _, ok := offsets[target]; !ok {
+					offsets[target] = p.length(target)
+				}
+			}
+			if last {
+				mode = marker
+			}
+		}
+		switch {
+		case mode == cfgmerge.MarkerAppend:
+		case mode != "" || !overlay[k].object:
+			p.Delete(target)
+		}
+		p[target] = overlay[k]
+	}
+}
+
+// add adds the positions of values below pointer, without applying merge markers.
+func (p Positions) add(pointer string, values Positions) {
+	for k, v := range values {
+		p[pointer+k] = v
+	}
+}
+
+// length returns the number of items of the array at pointer that have a position.
+func (p Positions) length(pointer string) int {
+	n := 0
+	for k := range p {
+		if !strings.HasPrefix(k, pointer+"/") || strings.Contains(k[len(pointer)+1:], "/") {
+			continue
+		}
+		if i, err := strconv.Atoi(k[len(pointer)+1:]); err == nil && i >= n {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// pointerElements returns the unescaped elements of pointer.
+func pointerElements(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	elements := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, e := range elements {
+		elements[i] = strings.ReplaceAll(strings.ReplaceAll(e, "~1", "/"), "~0", "~")
+	}
+	return elements
+}
+
+// sub returns the positions below pointer, relative to pointer.
+func (p Positions) sub(pointer string) Positions {
+	ret := make(Positions)
+	for k, v := range p {
+		if k == pointer || strings.HasPrefix(k, pointer+"/") {
+			ret[k[len(pointer):]] = v
+		}
+	}
+	return ret
+}
+
+func (p Positions) setFile(filename string) {
+	for k, v := range p {
+		v.File = filename
+		p[k] = v
+	}
+}
@@ -0,0 +1,109 @@
+package cfgfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodePositions(t *testing.T) {
+	tests := []struct {
+		format, data string
+		expect       map[string]string
+	}{
+		{"json", "{\n  \"a\": {\"b\": [1, \"x\\\"y\"]},\n  \"c/d\": true\n}", map[string]string{
+			"": ":1:1", "/a": ":2:8", "/a/b": ":2:14", "/a/b/0": ":2:15", "/a/b/1": ":2:18", "/c~1d": ":3:10",
+		}},
+		{"yaml", "base: &base\n  x: 1\na:\n  <<: *base\n  y: [2, 3]\n", map[string]string{
+			"/base/x": ":2:6", "/a": ":4:3", "/a/x": ":2:6", "/a/y": ":5:6", "/a/y/1": ":5:10",
+		}},
+		{"hcl", "name = \"x\"\nserver \"a\" {\n  port = 1\n}\nlist = [1, {k = 2}]\n", map[string]string{
+			"/name": ":1:8", "/server": ":2:1", "/server/a/port": ":3:10", "/list/1/k": ":5:17",
+		}},
+		{"toml", "name = \"x\" # comment\n[server.a]\nport = 1\nlist = [1, {k = 2},\n  \"\"\"s]\n\"\"\"]\n" +
+			"[[nic]]\n\"n.1\" = 'eth0'\n[[nic]]\nip.v4 = 1979-05-27 07:32:00\n", map[string]string{
+			"/name": ":1:8", "/server": ":2:1", "/server/a": ":2:1", "/server/a/port": ":3:8", "/server/a/list": ":4:8",
+			"/server/a/list/1": ":4:12", "/server/a/list/1/k": ":4:17", "/server/a/list/2": ":5:3", "/nic": ":7:1",
+			"/nic/0": ":7:1", "/nic/0/n.1": ":8:9", "/nic/1": ":9:1", "/nic/1/ip": ":10:1", "/nic/1/ip/v4": ":10:9",
+		}},
+		{"kv", "# comment\na.b (int)= 1\na.c=\"x\"\n", map[string]string{
+			"/a": ":2:1", "/a/b": ":2:12", "/a/c": ":3:5",
+		}},
+	}
+	for _, test := range tests {
+		data, positions, err := DecodePositions(test.format, []byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %s", test.format, err)
+			continue
+		}
+		if expect, _ := Decode(test.format, []byte(test.data)); !reflect.DeepEqual(data, expect) {
+			t.Errorf("%s: wrong data %v", test.format, data)
+		}
+		for pointer, expect := range test.expect {
+			if pos, ok := positions[pointer]; !ok || pos.String() != expect {
+				t.Errorf("%s: %s at %s, expected %s", test.format, pointer, pos, expect)
+			}
+		}
+	}
+}
+
+func TestLoadFilePositions(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"host.json": "{\"$include\": \"base.yaml\",\n \"name\": \"host\",\n \"ntp\": {\"$ref\": \"ntp.json#/servers\"}}",
+		"base.yaml": "name: base\nzone: a\n",
+		"ntp.json":  "{\"servers\": [\n  \"0.pool.ntp.org\"]}",
+	})
+	defer func() { _ = os.RemoveAll(dir) }()
+	_, positions, err := LoadFilePositions(filepath.Join(dir, "host.json"), "")
+	if err != nil {
+		t.Fatalf("LoadFilePositions: %s", err)
+	}
+	expect := map[string]string{
+		"/name":  "host.json:2:10",
+		"/zone":  "base.yaml:2:7",
+		"/ntp":   "ntp.json:1:13",
+		"/ntp/0": "ntp.json:2:3",
+	}
+	for pointer, e := range expect {
+		pos, ok := positions.Lookup(pointer)
+		if !ok || pos.String() != filepath.Join(dir, e) {
+			t.Errorf("%s at %s, expected %s", pointer, pos, e)
+		}
+	}
+	if pos, _ := positions.Lookup("/zone/missing"); pos.String() != filepath.Join(dir, "base.yaml:2:7") {
+		t.Errorf("Lookup of missing value returned %s", pos)
+	}
+}
+
+func TestDecodePositionsErrors(t *testing.T) {
+	for _, s := range []string{"", "{\"a\": ", "{} {}", "[1,]", "{\"a\" 1}"} {
+		if _, _, err := DecodePositions("json", []byte(s)); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestPositionsMerge(t *testing.T) {
+	at := func(line int, object bool) Position {
+		return Position{Line: line, Column: 1, object: object}
+	}
+	positions := Positions{
+		"": at(1, true), "/dns": at(2, false), "/dns/0": at(3, false), "/debug": at(4, false),
+		"/network": at(5, false), "/network/0": at(6, false), "/site": at(7, true), "/site/zone": at(8, false),
+	}
+	positions.Merge("", Positions{
+		"": at(11, true), "/dns%append": at(12, false), "/dns%append/0": at(13, false), "/debug%delete": at(14, false),
+		"/network%merge=nic": at(15, false), "/network%merge=nic/0": at(16, false),
+		"/site%replace": at(17, true), "/site%replace/name": at(18, false),
+	})
+	expect := map[string]int{"": 11, "/dns": 12, "/dns/0": 3, "/dns/1": 13, "/network": 15, "/site": 17, "/site/name": 18}
+	if len(positions) != len(expect) {
+		t.Errorf("Wrong positions: %v", positions)
+	}
+	for pointer, line := range expect {
+		if positions[pointer].Line != line {
+			t.Errorf("%s at line %d, expected %d", pointer, positions[pointer].Line, line)
+		}
+	}
+}
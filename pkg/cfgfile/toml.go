@@ -0,0 +1,283 @@
+package cfgfile
+
+import (
+	"github.com/BurntSushi/toml"
+	"strconv"
+	"strings"
+)
+
+func decodeTOML(d []byte) (interface{}, error) {
+	var ret map[string]interface{}
+	if _, err := toml.Decode(string(d), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// tomlPositions decodes TOML like decodeTOML and returns the positions of tables, keys and the elements of arrays
+// and inline tables. The decoder does not report positions, so they are found by a scan of the document, which has
+// been decoded successfully before.
+func tomlPositions(d []byte) (interface{}, Positions, error) {
+	ret, err := decodeTOML(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &tomlScanner{
+		s:         []rune(string(d)),
+		line:      1,
+		col:       1,
+		positions: Positions{"": Position{Line: 1, Column: 1, object: true}},
+		arrays:    make(map[string]int),
+	}
+	s.document()
+	return ret, s.positions, nil
+}
+
+// tomlScanner finds the positions of values in a TOML document.
+type tomlScanner struct {
+	s         []rune
+	i         int
+	line, col int
+	positions Positions
+	arrays    map[string]int // Index of the last table of arrays of tables, by pointer.
+}
+
+func (s *tomlScanner) peek(n int) rune {
+	if s.i+n < len(s.s) {
+		return s.s[s.i+n]
+	}
+	return 0
+}
+
+func (s *tomlScanner) next() {
+	if s.i >= len(s.s) {
+		return
+	}
+	if s.s[s.i] == '\n' {
+		s.line, s.col = s.line+1, 1
+	} else {
+		s.col++
+	}
+	s.i++
+}
+
+func (s *tomlScanner) position(object bool) Position {
+	return Position{Line: s.line, Column: s.col, object: object}
+}
+
+func (s *tomlScanner) has(prefix string) bool {
+	for i, c := range []rune(prefix) {
+		if s.peek(i) != c {
+			return false
+		}
+	}
+	return true
+}
+
+// space skips blanks, and newlines and comments if lines is set.
+func (s *tomlScanner) space(lines bool) {
+	for s.i < len(s.s) {
+		switch c := s.s[s.i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+		case lines && c == '\n':
+		case lines && c == '#':
+			for s.i < len(s.s) && s.s[s.i] != '\n' {
+				s.next()
+			}
+			continue
+		default:
+			return
+		}
+		s.next()
+	}
+}
+
+func (s *tomlScanner) document() {
+	table := ""
+	for {
+		s.space(true)
+		if s.i >= len(s.s) {
+			return
+		}
+		pos := s.position(true)
+		switch {
+		case s.has("[["):
+			s.next()
+			s.next()
+			table = s.table(s.key(), true, pos)
+		case s.has("["):
+			s.next()
+			table = s.table(s.key(), false, pos)
+		default:
+			if !s.keyValue(table) {
+				return
+			}
+		}
+		// Skip the rest of the line, the closing brackets of headers and comments.
+		for s.i < len(s.s) && s.s[s.i] != '\n' {
+			s.next()
+		}
+	}
+}
+
+// table returns the pointer of the table header with key. Tables below arrays of tables are in their last element.
+func (s *tomlScanner) table(key []string, array bool, pos Position) string {
+	pointer := ""
+	for i, e := range key {
+		pointer += Pointer(e)
+		if i == len(key)-1 && array {
+			n, ok := s.arrays[pointer]
+			if ok {
+				n++
+			} else {
+				s.positions[pointer] = Position{Line: pos.Line, Column: pos.Column}
+			}
+			s.arrays[pointer] = n
+			pointer += "/" + strconv.Itoa(n)
+		} else if n, ok := s.arrays[pointer]; ok {
+			pointer += "/" + strconv.Itoa(n)
+		}
+		if _, ok := s.positions[pointer]; !ok || i == len(key)-1 {
+			s.positions[pointer] = pos
+		}
+	}
+	return pointer
+}
+
+// keyValue reads key = value in table. Returns false if there is none.
+func (s *tomlScanner) keyValue(table string) bool {
+	start := s.position(true)
+	key := s.key()
+	s.space(false)
+	if len(key) == 0 || s.peek(0) != '=' {
+		return false
+	}
+	s.next()
+	s.space(false)
+	pointer := table
+	for _, e := range key[:len(key)-1] {
+		pointer += Pointer(e)
+		if _, ok := s.positions[pointer]; !ok {
+			s.positions[pointer] = start
+		}
+	}
+	s.value(pointer + Pointer(key[len(key)-1]))
+	return true
+}
+
+// key reads a dotted key.
+func (s *tomlScanner) key() []string {
+	var key []string
+	for {
+		s.space(false)
+		switch c := s.peek(0); {
+		case c == '"' || c == '\'':
+			key = append(key, s.str())
+		case isTOMLBare(c):
+			start := s.i
+			for isTOMLBare(s.peek(0)) {
+				s.next()
+			}
+			key = append(key, string(s.s[start:s.i]))
+		default:
+			return key
+		}
+		s.space(false)
+		if s.peek(0) != '.' {
+			return key
+		}
+		s.next()
+	}
+}
+
+func isTOMLBare(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// str reads a string and returns its value. Values of multi-line strings are not needed and not returned.
+func (s *tomlScanner) str() string {
+	quote := s.peek(0)
+	if s.has(strings.Repeat(string(quote), 3)) {
+		for n := 0; n < 3; n++ {
+			s.next()
+		}
+		for s.i < len(s.s) && !s.has(strings.Repeat(string(quote), 3)) {
+			if quote == '"' && s.peek(0) == '\\' {
+				s.next()
+			}
+			s.next()
+		}
+		// Up to two quotes before the closing quotes belong to the string.
+		for n := 0; n < 5 && s.peek(0) == quote; n++ {
+			s.next()
+		}
+		return ""
+	}
+	s.next()
+	start := s.i
+	for s.i < len(s.s) && s.peek(0) != quote && s.peek(0) != '\n' {
+		if quote == '"' && s.peek(0) == '\\' {
+			s.next()
+		}
+		s.next()
+	}
+	raw := string(s.s[start:s.i])
+	s.next()
+	if quote == '\'' {
+		return raw
+	}
+	if v, err := strconv.Unquote(`"` + raw + `"`); err == nil {
+		return v
+	}
+	return raw
+}
+
+// value reads the value at pointer.
+func (s *tomlScanner) value(pointer string) {
+	switch c := s.peek(0); c {
+	case '"', '\'':
+		s.positions[pointer] = s.position(false)
+		s.str()
+	case '[':
+		s.positions[pointer] = s.position(false)
+		s.next()
+		for n := 0; ; n++ {
+			s.space(true)
+			if s.i >= len(s.s) || s.peek(0) == ']' {
+				break
+			}
+			s.value(pointer + "/" + strconv.Itoa(n))
+			s.space(true)
+			if s.peek(0) != ',' {
+				break
+			}
+			s.next()
+		}
+		s.next()
+	case '{':
+		s.positions[pointer] = s.position(true)
+		s.next()
+		for {
+			s.space(true)
+			if s.i >= len(s.s) || s.peek(0) == '}' || !s.keyValue(pointer) {
+				break
+			}
+			s.space(true)
+			if s.peek(0) != ',' {
+				break
+			}
+			s.next()
+		}
+		s.next()
+	default:
+		s.positions[pointer] = s.position(false)
+		for s.i < len(s.s) && !strings.ContainsRune(",]}\n#", s.peek(0)) {
+			s.next()
+		}
+	}
+}
+
+func init() {
+	RegisterFormat("toml", decodeTOML, ".toml")
+	RegisterPositions("toml", tomlPositions)
+}
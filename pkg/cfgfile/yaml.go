@@ -0,0 +1,105 @@
+package cfgfile
+
+import (
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+const yamlMergeTag = "!!merge"
+
+// decodeYAML decodes YAML. Anchors, aliases and merge keys are resolved by the decoder.
+func decodeYAML(d []byte) (interface{}, error) {
+	var ret interface{}
+	if err := yaml.Unmarshal(d, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// yamlPositions decodes YAML and returns the positions of its values. Values of aliases and merge keys are reported
+// at their anchor.
+func yamlPositions(d []byte) (interface{}, Positions, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(d, &root); err != nil {
+		return nil, nil, err
+	}
+	var ret interface{}
+	if err := root.Decode(&ret); err != nil {
+		return nil, nil, err
+	}
+	positions := make(Positions)
+	yamlNodePositions(positions, &root, "")
+	return ret, positions, nil
+}
+
+func yamlNodePositions(positions Positions, n *yaml.Node, pointer string) {
+	if n.Kind == yaml.DocumentNode {
+		if len(n.Content) > 0 {
+			yamlNodePositions(positions, n.Content[0], pointer)
+		}
+		return
+	}
+	positions[pointer] = Position{Line: n.Line, Column: n.Column, object: n.Kind == yaml.MappingNode}
+	switch n.Kind {
+	case yaml.AliasNode:
+		if n.Alias != nil {
+			yamlNodePositions(positions, n.Alias, pointer)
+			positions[pointer] = Position{Line: n.Line, Column: n.Column, object: n.Alias.Kind == yaml.MappingNode}
+		}
+	case yaml.SequenceNode:
+		for i, e := range n.Content {
+			yamlNodePositions(positions, e, pointer+"/"+strconv.Itoa(i))
+		}
+	case yaml.MappingNode:
+		var merged []*yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Tag == yamlMergeTag {
+				merged = append(merged, n.Content[i+1])
+				continue
+			}
+			yamlNodePositions(positions, n.Content[i+1], pointer+Pointer(n.Content[i].Value))
+		}
+		// Merged keys do not replace keys of the mapping or of earlier merged mappings.
+		for _, m := range yamlMergeSources(merged) {
+			sub := make(Positions)
+			yamlNodePositions(sub, m, "")
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				if m.Content[i].Tag == yamlMergeTag {
+					continue
+				}
+				key := Pointer(m.Content[i].Value)
+				if _, exists := positions[pointer+key]; exists {
+					continue
+				}
+				for k, v := range sub {
+					if k == key || strings.HasPrefix(k, key+"/") {
+						positions[pointer+k] = v
+					}
+				}
+			}
+		}
+	}
+}
+
+// yamlMergeSources returns the mappings merged by merge keys, in order of precedence.
+func yamlMergeSources(nodes []*yaml.Node) []*yaml.Node {
+	var ret []*yaml.Node
+	for _, n := range nodes {
+		if n.Kind == yaml.AliasNode && n.Alias != nil {
+			n = n.Alias
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			ret = append(ret, n)
+		case yaml.SequenceNode:
+			ret = append(ret, yamlMergeSources(n.Content)...)
+		}
+	}
+	return ret
+}
+
+func init() {
+	RegisterFormat("yaml", decodeYAML, ".yaml", ".yml")
+	RegisterPositions("yaml", yamlPositions)
+}
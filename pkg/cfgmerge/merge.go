@@ -0,0 +1,224 @@
+package cfgmerge
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keys in an overlay can carry a marker that controls how the value is merged with the base:
+//
+//	"key%replace": replace the base value (default for arrays and scalars). Maps are replaced instead of merged.
+//	"key%append": append the overlay array to the base array.
+//	"key%merge=field": merge arrays of objects by the value of field. Matching elements are merged, others appended.
+//	"key%delete": remove key from the base. The value is ignored.
+//
+// In arrays merged by key, an element containing "%delete": true removes the matching base element.
+// Maps without marker are merged recursively.
+
+// Merge markers.
+const (
+	markerSep     = "%"
+	MarkerReplace = "replace"
+	MarkerAppend  = "append"
+	MarkerMerge   = "merge"
+	MarkerDelete  = "delete"
+)
+
+var (
+	ErrMergeType = errors.New("merge requires arrays")
+	ErrMergeKey  = errors.New("merge by key requires objects with key field")
+)
+
+// Merge merges overlay into base and returns the result. Neither base nor overlay are modified.
+func Merge(base, overlay interface{}) (interface{}, error) {
+	return merge(base, overlay, "", "")
+}
+
+// MergeAll merges all layers in order, later layers taking precedence.
+func MergeAll(layers ...interface{}) (interface{}, error) {
+	var ret interface{}
+	for i, l := range layers {
+		var err error
+		if ret, err = Merge(ret, l); err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+	return ret, nil
+}
+
+// SplitMarker splits a key into name, merge marker and marker parameter ("network%merge=nic"). Keys without merge
+// marker are returned as name.
+func SplitMarker(key string) (name, marker, param string) {
+	pos := strings.LastIndex(key, markerSep)
+	if pos < 0 {
+		return key, "", ""
+	}
+	name, marker = key[:pos], key[pos+1:]
+	if p := strings.Index(marker, "="); p >= 0 {
+		marker, param = marker[:p], marker[p+1:]
+	}
+	switch marker {
+	case MarkerReplace, MarkerAppend, MarkerMerge, MarkerDelete:
+		return name, marker, param
+	}
+	// Not a merge marker, the key is used as is.
+	return key, "", ""
+}
+
+func merge(base, overlay interface{}, mode, param string) (interface{}, error) {
+	switch o := overlay.(type) {
+	case map[string]interface{}:
+		b, _ := base.(map[string]interface{})
+		if mode == MarkerReplace {
+			b = nil
+		}
+		return mergeMap(b, o)
+	case []interface{}:
+		switch mode {
+		case MarkerAppend:
+			b, ok := base.([]interface{})
+			if !ok && base != nil {
+				return nil, ErrMergeType
+			}
+			ret := make([]interface{}, 0, len(b)+len(o))
+			ret = append(ret, Copy(b).([]interface{})...)
+			for _, e := range o {
+				v, err := merge(nil, e, MarkerReplace, "")
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, v)
+			}
+			return ret, nil
+		case MarkerMerge:
+			b, ok := base.([]interface{})
+			if !ok && base != nil {
+				return nil, ErrMergeType
+			}
+			return mergeByKey(b, o, param)
+		}
+		ret := make([]interface{}, len(o))
+		for i, e := range o {
+			v, err := merge(nil, e, MarkerReplace, "")
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = v
+		}
+		return ret, nil
+	default:
+		if mode == MarkerAppend || mode == MarkerMerge {
+			return nil, ErrMergeType
+		}
+		return o, nil
+	}
+}
+
+func mergeMap(base, overlay map[string]interface{}) (interface{}, error) {
+	ret := make(map[string]interface{}, len(base)+len(overlay))
+	for k, v := range base {
+		ret[k] = Copy(v)
+	}
+	keys := make([]string, 0, len(overlay))
+	for k := range overlay {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := overlay[k]
+		name, marker, param := SplitMarker(k)
+		if marker == MarkerDelete {
+			delete(ret, name)
+			continue
+		}
+		n, err := merge(ret[name], v, marker, param)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ret[name] = n
+	}
+	return ret, nil
+}
+
+func mergeByKey(base, overlay []interface{}, field string) (interface{}, error) {
+	if field == "" {
+		return nil, ErrMergeKey
+	}
+	ret := Copy(base).([]interface{})
+	index := make(map[interface{}]int, len(ret))
+	for i, e := range ret {
+		m, ok := e.(map[string]interface{})
+		if !ok || !isKey(m[field]) {
+			return nil, ErrMergeKey
+		}
+		index[m[field]] = i
+	}
+	deleted := make(map[int]bool)
+	for _, e := range overlay {
+		m, ok := e.(map[string]interface{})
+		if !ok || !isKey(m[field]) {
+			return nil, ErrMergeKey
+		}
+		pos, exists := index[m[field]]
+		if del, _ := m[markerSep+MarkerDelete].(bool); del {
+			if exists {
+				deleted[pos] = true
+			}
+			continue
+		}
+		if !exists {
+			v, err := merge(nil, m, MarkerReplace, "")
+			if err != nil {
+				return nil, err
+			}
+			index[m[field]] = len(ret)
+			ret = append(ret, v)
+			continue
+		}
+		v, err := merge(ret[pos], m, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("%s=%v: %w", field, m[field], err)
+		}
+		ret[pos] = v
+	}
+	if len(deleted) == 0 {
+		return ret, nil
+	}
+	filtered := make([]interface{}, 0, len(ret)-len(deleted))
+	for i, e := range ret {
+		if !deleted[i] {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+func isKey(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// Copy returns a deep copy of the maps and arrays of v.
+func Copy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			ret[k] = Copy(e)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, e := range t {
+			ret[i] = Copy(e)
+		}
+		return ret
+	default:
+		return t
+	}
+}
@@ -0,0 +1,93 @@
+package cfgmerge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func parse(t *testing.T, s string) interface{} {
+	var ret interface{}
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	return ret
+}
+
+func TestMerge(t *testing.T) {
+	global := parse(t, `{
+		"ntp": ["0.pool.ntp.org"],
+		"dns": ["1.1.1.1"],
+		"network": [{"nic":"eth0","mtu":1500},{"nic":"eth1","mtu":1500}],
+		"site": {"name":"global","zone":"a"},
+		"proxy": {"host":"proxy.global","port":3128},
+		"debug": true
+	}`)
+	host := parse(t, `{
+		"ntp": ["ntp.local"],
+		"dns%append": ["8.8.8.8"],
+		"network%merge=nic": [{"nic":"eth0","mtu":9000},{"nic":"eth1","%delete":true},{"nic":"eth2"}],
+		"site": {"name":"host"},
+		"proxy%replace": {"host":"proxy.local"},
+		"debug%delete": null
+	}`)
+	expect := parse(t, `{
+		"ntp": ["ntp.local"],
+		"dns": ["1.1.1.1","8.8.8.8"],
+		"network": [{"nic":"eth0","mtu":9000},{"nic":"eth2"}],
+		"site": {"name":"host","zone":"a"},
+		"proxy": {"host":"proxy.local"}
+	}`)
+	r, err := MergeAll(global, host)
+	if err != nil {
+		t.Fatalf("MergeAll: %s", err)
+	}
+	if !reflect.DeepEqual(r, expect) {
+		t.Errorf("Wrong result: %v", r)
+	}
+	if global.(map[string]interface{})["debug"] != true {
+		t.Error("Base modified")
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	if _, err := Merge(parse(t, `{"a":1}`), parse(t, `{"a%append":2}`)); err == nil {
+		t.Error("Append to scalar accepted")
+	}
+	if _, err := Merge(parse(t, `{"a":[1]}`), parse(t, `{"a%merge=id":[{"id":1}]}`)); err == nil {
+		t.Error("Merge by key of scalars accepted")
+	}
+}
+
+func TestSet(t *testing.T) {
+	data := parse(t, `{"network":[{"nic":"eth0"}],"name":"x"}`)
+	for _, e := range []struct {
+		path  string
+		value interface{}
+	}{
+		{"network[0].gateway", "10.0.0.1"},
+		{"network.1.nic", "eth1"},
+		{"build.id", float64(42)},
+	} {
+		path, err := ParsePath(e.path)
+		if err != nil {
+			t.Fatalf("ParsePath %s: %s", e.path, err)
+		}
+		if data, err = Set(data, path, e.value); err != nil {
+			t.Fatalf("Set %s: %s", e.path, err)
+		}
+	}
+	expect := parse(t, `{"network":[{"nic":"eth0","gateway":"10.0.0.1"},{"nic":"eth1"}],"name":"x","build":{"id":42}}`)
+	if !reflect.DeepEqual(data, expect) {
+		t.Errorf("Wrong result: %v", data)
+	}
+	if _, err := Set(data, []string{"name", "sub"}, 1); err == nil {
+		t.Error("Set below scalar accepted")
+	}
+	if _, err := Set(data, []string{"network", "5"}, 1); err == nil {
+		t.Error("Index out of range accepted")
+	}
+	if _, err := ParsePath("a..b"); err == nil {
+		t.Error("Empty path element accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package cfgmerge
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPath      = errors.New("invalid path")
+	ErrPathIndex = errors.New("array index out of range")
+	ErrPathType  = errors.New("path element is not an object or array")
+)
+
+// ParsePath splits a path of the form "a.b[0].c" or "a.b.0.c" into its elements.
+func ParsePath(s string) ([]string, error) {
+	ret := make([]string, 0, 4)
+	for _, e := range strings.Split(s, ".") {
+		name := e
+		if pos := strings.Index(e, "["); pos >= 0 {
+			name, e = e[:pos], e[pos:]
+		} else {
+			e = ""
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: %w", s, ErrPath)
+		}
+		ret = append(ret, name)
+		for e != "" {
+			end := strings.Index(e, "]")
+			if e[0] != '[' || end < 2 {
+				return nil, fmt.Errorf("%s: %w", s, ErrPath)
+			}
+			ret = append(ret, e[1:end])
+			e = e[end+1:]
+		}
+	}
+	return ret, nil
+}
+
+// Set sets the value at path in data, creating objects and arrays as needed. Arrays can be extended by one element by
+// setting the index one past the end. Returns the modified data.
+func Set(data interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	if data == nil {
+		if _, err := strconv.Atoi(path[0]); err == nil {
+			data = make([]interface{}, 0, 1)
+		}
+	}
+	switch n := data.(type) {
+	case nil:
+		v, err := Set(nil, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{path[0]: v}, nil
+	case map[string]interface{}:
+		v, err := Set(n[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = v
+		return n, nil
+	case []interface{}:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx > len(n) {
+			return nil, fmt.Errorf("%s: %w", path[0], ErrPathIndex)
+		}
+		if idx == len(n) {
+			n = append(n, nil)
+		}
+		v, err := Set(n[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = v
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", path[0], ErrPathType)
+	}
+}
@@ -0,0 +1,193 @@
+package dnsquery
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	typeCAA        = dnsmessage.Type(257)
+	resolvConf     = "/etc/resolv.conf"
+	defaultServer  = "127.0.0.1:53"
+	queryTimeout   = 5 * time.Second
+	maxMessageSize = 65535
+)
+
+var (
+	ErrNoNameserver = errors.New("no nameserver")
+	ErrBadResponse  = errors.New("bad DNS response")
+	ErrNotFound     = errors.New("no such host")
+)
+
+// CAA is a Certification Authority Authorization record.
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// LookupCAA returns the CAA records of name. The stdlib resolver does not support CAA, so the query is sent
+// directly to the first nameserver in /etc/resolv.conf.
+func LookupCAA(name string) ([]CAA, error) {
+	server, err := nameserver()
+	if err != nil {
+		return nil, err
+	}
+	return lookupCAA(server, name)
+}
+
+// queryID returns a random query ID. IDs must not be predictable, to make spoofed responses harder.
+func queryID() (uint16, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func lookupCAA(server, name string) ([]CAA, error) {
+	fqdn, err := dnsmessage.NewName(dnsName(name))
+	if err != nil {
+		return nil, err
+	}
+	id, err := queryID()
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  fqdn,
+			Type:  typeCAA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	response, err := exchange("udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.Unpack(response); err != nil {
+		return nil, err
+	}
+	if msg.Header.Truncated {
+		if response, err = exchange("tcp", server, query); err != nil {
+			return nil, err
+		}
+		if err := msg.Unpack(response); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Header.ID != id {
+		return nil, ErrBadResponse
+	}
+	if msg.Header.RCode == dnsmessage.RCodeNameError {
+		return nil, ErrNotFound
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, ErrBadResponse
+	}
+	ret := make([]CAA, 0, len(msg.Answers))
+	for _, a := range msg.Answers {
+		if a.Header.Type != typeCAA {
+			continue
+		}
+		r, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		caa, err := parseCAA(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, caa)
+	}
+	return ret, nil
+}
+
+func parseCAA(d []byte) (CAA, error) {
+	if len(d) < 2 || len(d) < 2+int(d[1]) {
+		return CAA{}, ErrBadResponse
+	}
+	return CAA{
+		Flag:  d[0],
+		Tag:   string(d[2 : 2+int(d[1])]),
+		Value: string(d[2+int(d[1]):]),
+	}, nil
+}
+
+func dnsName(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func exchange(network, server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+	if network == "tcp" {
+		l := make([]byte, 2)
+		binary.BigEndian.PutUint16(l, uint16(len(query)))
+		if _, err := conn.Write(append(l, query...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(l))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func nameserver() (string, error) {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultServer, nil
+		}
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip != nil {
+			return net.JoinHostPort(ip.String(), "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrNoNameserver
+}
@@ -0,0 +1,70 @@
+package dnsquery
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"testing"
+)
+
+func TestParseCAA(t *testing.T) {
+	caa, err := parseCAA(append([]byte{128, 5}, []byte("issueletsencrypt.org")...))
+	if err != nil {
+		t.Fatalf("parseCAA: %s", err)
+	}
+	if caa.Flag != 128 || caa.Tag != "issue" || caa.Value != "letsencrypt.org" {
+		t.Errorf("Wrong record: %v", caa)
+	}
+	if _, err := parseCAA([]byte{0, 5, 'i'}); err == nil {
+		t.Error("Short record must fail")
+	}
+}
+
+// stubServer answers CAA queries with records, or with rcode if records is nil.
+func stubServer(t *testing.T, rcode dnsmessage.RCode, records ...CAA) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			msg.Header.Response, msg.Header.RCode = true, rcode
+			for _, r := range records {
+				data := append([]byte{r.Flag, byte(len(r.Tag))}, r.Tag+r.Value...)
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: typeCAA, Class: dnsmessage.ClassINET},
+					Body:   &dnsmessage.UnknownResource{Type: typeCAA, Data: data},
+				})
+			}
+			response, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupCAA(t *testing.T) {
+	expect := []CAA{{Flag: 0, Tag: "issue", Value: "letsencrypt.org"}, {Flag: 128, Tag: "iodef", Value: "mailto:a@b"}}
+	records, err := lookupCAA(stubServer(t, dnsmessage.RCodeSuccess, expect...), "example.com")
+	if err != nil {
+		t.Fatalf("lookupCAA: %s", err)
+	}
+	if len(records) != len(expect) || records[0] != expect[0] || records[1] != expect[1] {
+		t.Errorf("Wrong records: %v", records)
+	}
+	if _, err := lookupCAA(stubServer(t, dnsmessage.RCodeNameError), "example.com"); err != ErrNotFound {
+		t.Errorf("Missing name not reported: %v", err)
+	}
+}
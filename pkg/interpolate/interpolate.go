@@ -0,0 +1,220 @@
+package interpolate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// String values can reference other values of the same config by path: "https://${.Hostname}:${.port}".
+// A string consisting of a single reference is replaced by the referenced value, keeping its type. "$${" is
+// replaced by a literal "${". Expressions that are not paths, like "${HOME}", are kept unchanged. Referenced objects
+// and arrays are copied.
+
+const (
+	refStart  = "${"
+	refEnd    = "}"
+	refEscape = "$${"
+)
+
+var (
+	ErrSyntax = errors.New("invalid reference")
+	ErrCycle  = errors.New("reference cycle")
+	ErrTarget = errors.New("reference target not found")
+	ErrType   = errors.New("reference to object or array in string")
+)
+
+// Error is a reference error in the value at Path.
+type Error struct {
+	Path []string // Path of the value containing the reference. Array indices are numbers.
+	Expr string   // Reference expression, empty for cycles.
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Expr == "" {
+		return fmt.Sprintf("%s: %s", pathString(e.Path), e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", pathString(e.Path), e.Expr, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func refError(path []string, expr string, err error) error {
+	return &Error{Path: append([]string{}, path...), Expr: expr, Err: err}
+}
+
+const (
+	stateVisiting = iota + 1
+	stateDone
+)
+
+type resolver struct {
+	root  interface{}
+	state map[string]int
+}
+
+// Resolve replaces all references in data. Data is modified in place.
+func Resolve(data interface{}) (interface{}, error) {
+	r := &resolver{
+		root:  data,
+		state: make(map[string]int),
+	}
+	return r.node(data, nil)
+}
+
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func pathString(path []string) string {
+	return "." + strings.Join(path, ".")
+}
+
+func (r *resolver) node(n interface{}, path []string) (interface{}, error) {
+	key := pathKey(path)
+	switch r.state[key] {
+	case stateDone:
+		return n, nil
+	case stateVisiting:
+		return nil, refError(path, "", ErrCycle)
+	}
+	r.state[key] = stateVisiting
+	switch t := n.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, err := r.node(t[k], append(path[:len(path):len(path)], k))
+			if err != nil {
+				return nil, err
+			}
+			t[k] = v
+		}
+	case []interface{}:
+		for i, e := range t {
+			v, err := r.node(e, append(path[:len(path):len(path)], strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			t[i] = v
+		}
+	case string:
+		v, err := r.str(t, path)
+		if err != nil {
+			return nil, err
+		}
+		n = v
+	}
+	r.state[key] = stateDone
+	return n, nil
+}
+
+func (r *resolver) str(s string, path []string) (interface{}, error) {
+	if !strings.Contains(s, refStart) {
+		return s, nil
+	}
+	if strings.HasPrefix(s, refStart) && strings.Index(s, refEnd) == len(s)-len(refEnd) {
+		expr := s[len(refStart) : len(s)-len(refEnd)]
+		if !isRef(expr) {
+			return s, nil
+		}
+		return r.ref(expr, path)
+	}
+	b := new(strings.Builder)
+	for len(s) > 0 {
+		if strings.HasPrefix(s, refEscape) {
+			b.WriteString(refStart)
+			s = s[len(refEscape):]
+			continue
+		}
+		if !strings.HasPrefix(s, refStart) {
+			b.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		end := strings.Index(s, refEnd)
+		if end < 0 {
+			if isRef(s[len(refStart):]) {
+				return nil, refError(path, s, ErrSyntax)
+			}
+			b.WriteString(s)
+			break
+		}
+		if !isRef(s[len(refStart):end]) {
+			b.WriteString(s[:end+len(refEnd)])
+			s = s[end+len(refEnd):]
+			continue
+		}
+		v, err := r.ref(s[len(refStart):end], path)
+		if err != nil {
+			return nil, err
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, refError(path, s[:end+1], ErrType)
+		case nil:
+		default:
+			b.WriteString(fmt.Sprint(v))
+		}
+		s = s[end+len(refEnd):]
+	}
+	return b.String(), nil
+}
+
+// isRef returns true if expr refers to a path. Other expressions are not interpolated.
+func isRef(expr string) bool {
+	return strings.HasPrefix(strings.TrimSpace(expr), ".")
+}
+
+func (r *resolver) ref(expr string, path []string) (interface{}, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, ".") || len(expr) < 2 {
+		return nil, refError(path, expr, ErrSyntax)
+	}
+	target, err := cfgmerge.ParsePath(expr[1:])
+	if err != nil {
+		return nil, refError(path, expr, ErrSyntax)
+	}
+	v, ok := lookup(r.root, target)
+	if !ok {
+		return nil, refError(path, expr, ErrTarget)
+	}
+	if v, err = r.node(v, target); err != nil {
+		return nil, err
+	}
+	if r.root, err = cfgmerge.Set(r.root, target, v); err != nil {
+		return nil, err
+	}
+	return cfgmerge.Copy(v), nil
+}
+
+func lookup(d interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		switch n := d.(type) {
+		case map[string]interface{}:
+			v, ok := n[p]
+			if !ok {
+				return nil, false
+			}
+			d = v
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, false
+			}
+			d = n[i]
+		default:
+			return nil, false
+		}
+	}
+	return d, true
+}
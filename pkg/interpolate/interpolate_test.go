@@ -0,0 +1,72 @@
+package interpolate
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func parse(t *testing.T, s string) interface{} {
+	var ret interface{}
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	return ret
+}
+
+func TestResolve(t *testing.T) {
+	data := parse(t, `{
+		"Hostname": "ab.com",
+		"port": 8080,
+		"url": "https://${.Hostname}:${.port}/${.path}",
+		"path": "${.paths[1]}",
+		"paths": ["a", "b"],
+		"copy": "${.port}",
+		"nics": "${.network}",
+		"network": {"nic": "eth0", "gw": "${.Hostname}"},
+		"literal": "$${.Hostname}",
+		"env": "${HOME}",
+		"shell": "cd ${HOME} && ${.Hostname} ${x"
+	}`)
+	expect := parse(t, `{
+		"Hostname": "ab.com",
+		"port": 8080,
+		"url": "https://ab.com:8080/b",
+		"path": "b",
+		"paths": ["a", "b"],
+		"copy": 8080,
+		"nics": {"nic": "eth0", "gw": "ab.com"},
+		"network": {"nic": "eth0", "gw": "ab.com"},
+		"literal": "${.Hostname}",
+		"env": "${HOME}",
+		"shell": "cd ${HOME} && ab.com ${x"
+	}`)
+	r, err := Resolve(data)
+	if err != nil {
+		t.Fatalf("Resolve: %s", err)
+	}
+	if !reflect.DeepEqual(r, expect) {
+		t.Errorf("Wrong result: %v", r)
+	}
+	m := r.(map[string]interface{})
+	m["nics"].(map[string]interface{})["nic"] = "eth1"
+	if m["network"].(map[string]interface{})["nic"] != "eth0" {
+		t.Error("Referenced object not copied")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	for s, expect := range map[string]error{
+		`{"a":"${.b}","b":"x${.a}"}`:  ErrCycle,
+		`{"a":{"b":"${.a}"}}`:         ErrCycle,
+		`{"a":"${.c}"}`:               ErrTarget,
+		`{"a":"${.}"}`:                ErrSyntax,
+		`{"a":"x ${.b","b":1}`:        ErrSyntax,
+		`{"a":"x ${.b}","b":{"c":1}}`: ErrType,
+	} {
+		if _, err := Resolve(parse(t, s)); !errors.Is(err, expect) {
+			t.Errorf("%s: wrong error: %v", s, err)
+		}
+	}
+}
@@ -1,10 +1,231 @@
 package jsonschema
 
+import (
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result contains the outcome of a validation: The modified data if validation succeeded, the values of all fields
+// marked "%secret", the violations and the warnings sorted by path. Warnings report keys not defined by the schema
+// outside of strict mode, they do not fail validation. Branches contains the names of the alternatives of unions
+// and oneOf that matched, by JSON pointer of the value. SecretPointers contains the sorted JSON pointers of the secret
+// values, objects and arrays.
+type Result struct {
+	Data           interface{}
+	Secrets        []string
+	SecretPointers []string
+	Violations     Violations
+	Warnings       Violations
+	Branches       map[string]string
+	defaults       map[string]interface{} // Default values as written in the schema, by JSON pointer.
+}
+
+// WithDefaults returns a copy of data with the default values of the schema inserted where data has no value. Unlike
+// Data, the values of data are not converted by the validators and defaults are as written in the schema, so that
+// "90s" stays "90s" and "10.0.0.1/24" keeps its prefix. Data must be the data that was validated.
+func (r *Result) WithDefaults(data interface{}) interface{} {
+	pointers := make([]string, 0, len(r.defaults))
+	for k := range r.defaults {
+		pointers = append(pointers, k)
+	}
+	// Parents before children, array items in order.
+	sort.Slice(pointers, func(i, j int) bool {
+		return pointerLess(pointers[i], pointers[j])
+	})
+	ret := cfgmerge.Copy(data)
+	for _, p := range pointers {
+		path, err := pointerElements(p)
+		if err != nil {
+			continue
+		}
+		ret = setDefault(ret, path, r.defaults[p])
+	}
+	return ret
+}
+
+// pointerLess orders pointers by their elements, numeric elements by value.
+func pointerLess(a, b string) bool {
+	ea, eb := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(ea) && i < len(eb); i++ {
+		if ea[i] == eb[i] {
+			continue
+		}
+		na, errA := strconv.Atoi(ea[i])
+		nb, errB := strconv.Atoi(eb[i])
+		if errA == nil && errB == nil {
+			return na < nb
+		}
+		return ea[i] < eb[i]
+	}
+	return len(ea) < len(eb)
+}
+
+// setDefault sets value at path in data, creating missing maps and array items. Existing values are kept.
+func setDefault(data interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		if data == nil {
+			return value
+		}
+		return data
+	}
+	i, err := strconv.Atoi(path[0])
+	switch d := data.(type) {
+	case map[string]interface{}:
+		d[path[0]] = setDefault(d[path[0]], path[1:], value)
+		return d
+	case []interface{}:
+		if err != nil || i < 0 {
+			return d
+		}
+		for len(d) <= i {
+			d = append(d, nil)
+		}
+		d[i] = setDefault(d[i], path[1:], value)
+		return d
+	case nil:
+		if err == nil && i >= 0 {
+			return setDefault(make([]interface{}, 0, i+1), path, value)
+		}
+		return setDefault(make(map[string]interface{}), path, value)
+	default:
+		return data
+	}
+}
+
+// Option is an option of a validation.
+type Option func(v *validator)
+
+// Strict sets the strict mode of maps without "%strict" directive. In strict mode, keys not defined by the schema are
+// violations. Otherwise, the default, they are kept and reported as warnings. Maps of a schema can override the
+// default with "%strict": true or false, which applies to the map and the maps below it.
+func Strict(strict bool) Option {
+	return func(v *validator) {
+		v.strict = strict
+	}
+}
+
+// Loader sets the function that loads the schema files named types refer to ("file.json#type"). Each file is loaded
+// once per validation. Without loader, references to schema files are violations.
+func Loader(loader SchemaLoader) Option {
+	return func(v *validator) {
+		v.scope.files.loader = loader
+	}
+}
+
+// SchemaFile sets the file name of the schema. References to schema files are relative to the directory of the file
+// that contains them, SchemaFile is that file for the schema itself.
+func SchemaFile(name string) Option {
+	return func(v *validator) {
+		v.scope.file = name
+	}
+}
+
 // Validate that data conforms to schema. Returns error and violating path.
-func Validate(schema, data interface{}) (errPath []string, modified interface{}, err error) {
-	pErr, d, err := validate(schema, data, false)
+func Validate(schema, data interface{}, options ...Option) (errPath []string, modified interface{}, err error) {
+	errPath, result, err := ValidateResult(schema, data, options...)
 	if err != nil {
-		return reverseStringSlice(pErr), nil, err
+		return errPath, nil, err
+	}
+	return nil, result.Data, nil
+}
+
+// ValidateResult validates like Validate and returns the first violation. The result contains the secret values
+// found, also if validation fails.
+func ValidateResult(schema, data interface{}, options ...Option) (errPath []string, result *Result, err error) {
+	result = ValidateAll(schema, data, options...)
+	if len(result.Violations) > 0 {
+		return result.Violations[0].Path, result, result.Violations[0].Err
+	}
+	return nil, result, nil
+}
+
+// ValidateAll validates data against schema and returns all violations.
+func ValidateAll(schema, data interface{}, options ...Option) *Result {
+	v := newValidator(false)
+	for _, o := range options {
+		o(v)
+	}
+	d, ok := v.validate(nil, schema, data, false, false)
+	if len(v.rules) > 0 {
+		v.checkRules(d, (&Result{defaults: v.defaults}).WithDefaults(data))
+	}
+	v.violations.sort()
+	v.warnings.sort()
+	pointers := make([]string, 0, len(v.pointers))
+	for k := range v.pointers {
+		pointers = append(pointers, k)
+	}
+	sort.Strings(pointers)
+	result := &Result{
+		Secrets:        v.secrets,
+		SecretPointers: pointers,
+		Violations:     v.violations,
+		Warnings:       v.warnings,
+		Branches:       v.branches,
+		defaults:       v.defaults,
+	}
+	if ok && len(v.violations) == 0 {
+		result.Data = d
+	}
+	return result
+}
+
+// TypeAt returns the name of the type that schema defines at path. Path elements that select array entries are
+// ignored. Returns false if the path is not defined by the schema. Options are those of the validation.
+func TypeAt(schema interface{}, path []string, options ...Option) (string, bool) {
+	scope := optionScope(options)
+	var err error
+	for _, p := range path {
+		if schema, scope, _, _, err = scope.resolve(schema); err != nil {
+			return "", false
+		}
+		switch m := schema.(type) {
+		case map[string]interface{}:
+			if scope, err = scope.with(m); err != nil {
+				return "", false
+			}
+			var found bool
+			if schema, found = lookupKey(m, p); !found {
+				return "", false
+			}
+		case []interface{}:
+			a, err := parseArray(m)
+			if err != nil {
+				return "", false
+			}
+			i, err := strconv.Atoi(p)
+			if err != nil {
+				return "", false
+			}
+			var found bool
+			if schema, found = a.item(i); !found {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+	if schema, _, _, _, err = scope.resolve(schema); err != nil {
+		return "", false
+	}
+	if s, ok := schema.(string); ok {
+		funcName, _ := nameRequired(s)
+		if funcName == "" {
+			funcName = defaultType
+		}
+		funcName, _, _ = extractParameters(funcName)
+		return funcName, true
+	}
+	return "", false
+}
+
+// optionScope returns the scope of the top of a schema validated with options.
+func optionScope(options []Option) *typeScope {
+	v := newValidator(false)
+	for _, o := range options {
+		o(v)
 	}
-	return nil, d, nil
+	return v.scope
 }
@@ -0,0 +1,124 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Arrays:
+//
+//	["int"]: All items are ints.
+//	["string", "int"]: Tuple, the first item is a string, the second an int. No further items are allowed.
+//	["%array(min=1,max=4,unique=nic)", {...}]: A leading "%array(...)" element constrains the number of items (min,
+//	max) and their uniqueness. "unique" compares whole items, "unique=field" the field of object items.
+
+// arrayDef is the parsed schema of an array.
+type arrayDef struct {
+	items       []interface{} // Schema of all items, or of each position for tuples.
+	params      ParamMap
+	unique      bool
+	uniqueField string
+}
+
+// parseArray parses an array schema.
+func parseArray(schema []interface{}) (*arrayDef, error) {
+	a := new(arrayDef)
+	if len(schema) > 0 {
+		if s, ok := schema[0].(string); ok && strings.HasPrefix(s, markerSep+ruleArray) {
+			name, params, err := extractParameters(strings.TrimPrefix(s, markerSep))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", ErrSchemaDefSyntax, s, err)
+			}
+			if name != ruleArray {
+				return nil, fmt.Errorf("%w: %s", ErrSchemaDefSyntax, s)
+			}
+			for k := range params {
+				switch {
+				case k == "min" || k == "max":
+					if _, _, err := params.AsInt(k); err != nil {
+						return nil, fmt.Errorf("%w: %s: %s", ErrParamType, s, k)
+					}
+				case strings.EqualFold(k, paramUnique):
+				default:
+					return nil, fmt.Errorf("%w: %s: unknown parameter %s", ErrSchemaDefSyntax, s, k)
+				}
+			}
+			a.params = params
+			a.uniqueField, a.unique, _ = params.AsString(paramUnique)
+			a.unique = a.unique || params.HasFlag(paramUnique)
+			schema = schema[1:]
+		}
+	}
+	if len(schema) == 0 {
+		return nil, ErrArraySchema
+	}
+	a.items = schema
+	return a, nil
+}
+
+// tuple returns true if the schema gives the type of each position.
+func (a *arrayDef) tuple() bool {
+	return len(a.items) > 1
+}
+
+// item returns the schema of the item at position i.
+func (a *arrayDef) item(i int) (interface{}, bool) {
+	if !a.tuple() {
+		return a.items[0], true
+	}
+	if i < len(a.items) {
+		return a.items[i], true
+	}
+	return nil, false
+}
+
+// checkLength checks the number of items.
+func (a *arrayDef) checkLength(n int) error {
+	if a.tuple() && n > len(a.items) {
+		return lengthError("max", len(a.items), n)
+	}
+	if min, ok, _ := a.params.AsInt("min"); ok && int64(n) < min {
+		return lengthError("min", min, n)
+	}
+	if max, ok, _ := a.params.AsInt("max"); ok && int64(n) > max {
+		return lengthError("max", max, n)
+	}
+	return nil
+}
+
+// uniqueKey returns the value that must be unique for item, or false if item has none.
+func (a *arrayDef) uniqueKey(item interface{}) (interface{}, string, bool) {
+	if a.uniqueField == "" {
+		return item, "", item != nil
+	}
+	m, ok := item.(map[string]interface{})
+	if !ok || m[a.uniqueField] == nil {
+		return nil, "", false
+	}
+	return m[a.uniqueField], a.uniqueField, true
+}
+
+// duplicates returns the positions of items that repeat the unique value of an earlier item.
+func (a *arrayDef) duplicates(data []interface{}) map[int]error {
+	if !a.unique {
+		return nil
+	}
+	ret := make(map[int]error)
+	seen := make(map[string]bool)
+	for i, e := range data {
+		value, field, ok := a.uniqueKey(e)
+		if !ok {
+			continue
+		}
+		key, err := json.Marshal(value)
+		if err != nil {
+			key = []byte(fmt.Sprintf("%#v", value))
+		}
+		if seen[string(key)] {
+			ret[i] = &ConstraintError{Param: paramUnique, Limit: field, Value: value}
+		}
+		seen[string(key)] = true
+	}
+	return ret
+}
@@ -0,0 +1,218 @@
+package jsonschema
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/redact"
+	"strings"
+)
+
+// ConstraintError is returned if a value violates a parameter of its type, e.g. min or max.
+type ConstraintError struct {
+	Param string      // Parameter name.
+	Limit interface{} // Parameter value.
+	Value interface{} // Checked value, e.g. the length of a string.
+	What  string      // What was checked, e.g. "length". Empty for the value itself.
+}
+
+func (e *ConstraintError) Error() string {
+	switch e.Param {
+	case paramEnum:
+		return fmt.Sprintf("%s is not one of %v", formatValue(e.Value), e.Limit)
+	case paramPattern:
+		return fmt.Sprintf("%s does not match %s", formatValue(e.Value), formatValue(e.Limit))
+	case paramPrefix:
+		return fmt.Sprintf("%s does not start with %s", formatValue(e.Value), formatValue(e.Limit))
+	case paramSuffix:
+		return fmt.Sprintf("%s does not end with %s", formatValue(e.Value), formatValue(e.Limit))
+	case paramContains:
+		return fmt.Sprintf("%s does not contain %s", formatValue(e.Value), formatValue(e.Limit))
+	case paramUnique:
+		if field, _ := e.Limit.(string); field != "" {
+			return fmt.Sprintf("duplicate %s %s", field, formatValue(e.Value))
+		}
+		return "duplicate " + describeValue(e.Value)
+	}
+	var relation string
+	switch e.Param {
+	case "min":
+		relation = "is below"
+	case "max":
+		relation = "is above"
+	default:
+		relation = "does not match"
+	}
+	what := ""
+	if e.What != "" {
+		what = e.What + " "
+	}
+	return fmt.Sprintf("%s%s %s %s=%v", what, formatValue(e.Value), relation, e.Param, e.Limit)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return ErrParamConstraint
+}
+
+func constraintError(param string, limit, value interface{}) error {
+	return &ConstraintError{Param: param, Limit: limit, Value: value}
+}
+
+func lengthError(param string, limit interface{}, length int) error {
+	return &ConstraintError{Param: param, Limit: limit, Value: length, What: "length"}
+}
+
+// BranchError is returned if a value matches none of the alternatives of a union or oneOf, or more than one
+// alternative of oneOf.
+type BranchError struct {
+	Alternatives []string    // Names of the alternatives that did not match, or of all if Matches > 1.
+	Matches      int         // Number of matching alternatives.
+	Value        interface{} // Checked value.
+	Errs         []error     // Errors of the alternatives that did not match.
+}
+
+func (e *BranchError) Error() string {
+	if e.Matches > 1 {
+		return fmt.Sprintf("%s matches %d alternatives", describeValue(e.Value), e.Matches)
+	}
+	s := fmt.Sprintf("%s matches none of %s", describeValue(e.Value), strings.Join(e.Alternatives, "|"))
+	var details []string
+	for i, err := range e.Errs {
+		var t *TypeError
+		if !errors.As(err, &t) {
+			details = append(details, e.Alternatives[i]+": "+strings.ReplaceAll(err.Error(), "\n", ", "))
+		}
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, "; ") + ")"
+	}
+	return s
+}
+
+func (e *BranchError) Unwrap() error {
+	if e.Matches > 1 {
+		return ErrAmbiguousBranch
+	}
+	return ErrNoBranch
+}
+
+// describeValue formats scalars as value, objects and arrays by their type.
+func describeValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return "value " + formatValue(v)
+	}
+}
+
+// RuleError is returned if a rule of "%rules" is not satisfied or cannot be evaluated.
+type RuleError struct {
+	Rule string // Expression of the rule.
+	Err  error  // Evaluation error, nil if the rule is not satisfied.
+}
+
+func (e *RuleError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("rule %q: %s", e.Rule, e.Err)
+	}
+	return fmt.Sprintf("rule %q failed", e.Rule)
+}
+
+func (e *RuleError) Unwrap() error {
+	if e.Err != nil {
+		return e.Err
+	}
+	return ErrRule
+}
+
+// ParamError is a syntax error in the parameters of a type definition.
+type ParamError struct {
+	Column int // Position of the error in the type definition, starting at 1.
+	Msg    string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+func (e *ParamError) Unwrap() error {
+	return ErrSchemaDefSyntax
+}
+
+// TypeError is returned if a value does not match the type of the schema definition.
+type TypeError struct {
+	Type  string      // Expected type.
+	Value interface{} // Found value.
+	Err   error       // Cause, returned by Unwrap.
+}
+
+func (e *TypeError) Error() string {
+	if e.Type == "null" {
+		return fmt.Sprintf("value %s is not null", formatValue(e.Value))
+	}
+	return fmt.Sprintf("value %s is not %s %s", formatValue(e.Value), article(e.Type), e.Type)
+}
+
+func (e *TypeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports all type errors as ErrViolationType.
+func (e *TypeError) Is(target error) bool {
+	return target == ErrViolationType
+}
+
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", t)
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+func article(s string) string {
+	if s != "" && strings.ContainsRune("aeiou", rune(s[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// typeError wraps errors of validator functions into a TypeError. Constraint and parameter errors are returned as is.
+func typeError(typeName string, value interface{}, err error) error {
+	var c *ConstraintError
+	var b *BranchError
+	if errors.As(err, &c) || errors.As(err, &b) || errors.Is(err, ErrParamType) {
+		return err
+	}
+	return &TypeError{Type: typeName, Value: value, Err: err}
+}
+
+// redacted replaces secret values in violations.
+type redacted struct{}
+
+func (redacted) String() string {
+	return redact.Replacement
+}
+
+// redactError removes the value of a secret from err. Causes are dropped since they may contain the value.
+func redactError(err error) error {
+	switch e := err.(type) {
+	case *ConstraintError:
+		r := *e
+		if r.What == "" {
+			r.Value = redacted{}
+		}
+		return &r
+	case *TypeError:
+		return &TypeError{Type: e.Type, Value: redacted{}, Err: ErrViolationType}
+	case *BranchError:
+		return &BranchError{Alternatives: e.Alternatives, Matches: e.Matches, Value: redacted{}}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,112 @@
+package jsonschema
+
+// Extending schemas:
+//
+//	{"%extends": true, "port": "int(min=1024)", "name%required": "string"}
+//
+// A schema with "%extends": true extends the schema of its parent directory instead of replacing it. Maps are merged
+// recursively, other definitions of the extending schema replace the definitions of the same key. Markers of keys
+// are combined, so that an extending schema can mark more fields required. "%secret", "%required" and "%nullable" of
+// a replaced definition are kept as markers of the key. "%rules" are added to the rules of the parent, "%types" replace
+// types of the same name.
+
+// Extends returns true if schema extends the schema of its parent.
+func Extends(schema interface{}) bool {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	b, _ := m[markerSep+markerExtends].(bool)
+	return b
+}
+
+// Extend returns the schema child merged into parent. Neither schema is modified.
+func Extend(parent, child interface{}) interface{} {
+	c, ok := child.(map[string]interface{})
+	if !ok {
+		return child
+	}
+	p, ok := parent.(map[string]interface{})
+	if !ok {
+		p = make(map[string]interface{})
+	}
+	return extendMap(p, c)
+}
+
+func extendMap(parent, child map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(parent)+len(child))
+	keys := make(map[string]string, len(parent)) // Key of ret by name without markers.
+	for k, e := range parent {
+		ret[k] = e
+		if !isDirective(k) {
+			name, _ := splitMarkers(k)
+			keys[name] = k
+		}
+	}
+	for _, k := range sortedKeys(child) {
+		e := child[k]
+		switch k {
+		case markerSep + markerExtends:
+			continue
+		case markerSep + markerRules:
+			parentRules, _ := ret[k].([]interface{})
+			if childRules, ok := e.([]interface{}); ok {
+				e = append(append([]interface{}{}, parentRules...), childRules...)
+			}
+			ret[k] = e
+			continue
+		case markerSep + markerTypes:
+			parentTypes, _ := ret[k].(map[string]interface{})
+			childTypes, ok := e.(map[string]interface{})
+			if ok && parentTypes != nil {
+				types := make(map[string]interface{}, len(parentTypes)+len(childTypes))
+				for name, def := range parentTypes {
+					types[name] = def
+				}
+				for name, def := range childTypes {
+					types[name] = def
+				}
+				e = types
+			}
+			ret[k] = e
+			continue
+		}
+		if isDirective(k) {
+			ret[k] = e
+			continue
+		}
+		name, m := splitMarkers(k)
+		parentKey, ok := keys[name]
+		if !ok {
+			ret[k] = e
+			keys[name] = k
+			continue
+		}
+		_, parentMarkers := splitMarkers(parentKey)
+		for marker, value := range parentMarkers {
+			if !m.has(marker) {
+				m[marker] = value
+			}
+		}
+		if def, ok := ret[parentKey].(string); ok {
+			// The definition is replaced, its markers apply to the key.
+			_, defMarkers := splitMarkers(def)
+			for _, marker := range []string{markerSecret, markerRequired, markerNullable} {
+				if defMarkers.has(marker) {
+					m[marker] = ""
+				}
+			}
+		}
+		key := name + markerString(m)
+		parentMap, parentOk := ret[parentKey].(map[string]interface{})
+		childMap, childOk := e.(map[string]interface{})
+		delete(ret, parentKey)
+		if parentOk && childOk {
+			ret[key] = extendMap(parentMap, childMap)
+		} else {
+			ret[key] = e
+		}
+		keys[name] = key
+	}
+	return ret
+}
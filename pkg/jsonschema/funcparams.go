@@ -1,21 +1,33 @@
 package jsonschema
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
+// Type definitions have the form name(key=value, flag, "argument"). Keys are case-insensitive. Values are quoted
+// strings with Go escapes, numbers, true/false or bare words. Parameters without value are flags, quoted strings
+// without key are positional arguments.
+
 type ParamMap map[string]interface{}
 
+// paramArgs is the key of the positional arguments, which cannot be used as parameter name.
+const paramArgs = ""
+
 func (params ParamMap) AsFloat(key string) (float64, bool, error) {
 	if params == nil {
 		return 0, false, nil
 	}
 	if e, ok := params[key]; ok {
-		if e == nil {
+		switch eI := e.(type) {
+		case nil:
 			return 0, true, nil
-		}
-		if eI, ok := e.(string); ok {
+		case float64:
+			return eI, true, nil
+		case string:
 			eX, err := strconv.ParseFloat(eI, 64)
 			if err != nil {
 				return 0, false, err
@@ -31,10 +43,15 @@ func (params ParamMap) AsInt(key string) (int64, bool, error) {
 		return 0, false, nil
 	}
 	if e, ok := params[key]; ok {
-		if e == nil {
+		switch eI := e.(type) {
+		case nil:
 			return 0, true, nil
-		}
-		if eI, ok := e.(string); ok {
+		case float64:
+			if eI != float64(int32(eI)) {
+				return 0, true, ErrParamType
+			}
+			return int64(eI), true, nil
+		case string:
 			eX, err := strconv.ParseInt(eI, 10, 32)
 			if err != nil {
 				return 0, false, err
@@ -53,30 +70,186 @@ func (params ParamMap) AsString(key string) (string, bool, error) {
 		if e == nil {
 			return "", true, nil
 		}
-		if eI, ok := e.(string); ok {
-			return eI, true, nil
-		}
-		return "", true, ErrParamType
+		return paramString(e), true, nil
 	}
 	return "", false, nil
 }
 
-func extractParameters(s string) (funcName string, params ParamMap) {
-	if pos := strings.Index(s, "("); pos >= 0 {
-		if pos2 := strings.Index(s[1+pos:], ")"); pos2 >= 0 {
-			ret := make(ParamMap)
-			for _, e := range strings.Split(s[1+pos:1+pos+pos2], ",") {
-				key := strings.ToLower(trimString(e))
-				if posE := strings.Index(key, "="); posE >= 0 {
-					value := trimString(key[posE+1:])
-					key = trimString(key[:posE])
-					ret[key] = value
+// Args returns the positional arguments.
+func (params ParamMap) Args() []interface{} {
+	args, _ := params[paramArgs].([]interface{})
+	return args
+}
+
+// Flags returns the parameters without value, sorted.
+func (params ParamMap) Flags() []string {
+	ret := make([]string, 0, len(params))
+	for k, v := range params {
+		if v == nil && k != paramArgs {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// HasFlag returns true if the flag name is given. Flags keep their case for values like enum(a|B), so flag names
+// are compared case-insensitive.
+func (params ParamMap) HasFlag(name string) bool {
+	for k, v := range params {
+		if v == nil && strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func paramString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+type paramParser struct {
+	s []rune
+	i int
+}
+
+func (p *paramParser) fail(format string, v ...interface{}) error {
+	return &ParamError{Column: p.i + 1, Msg: fmt.Sprintf(format, v...)}
+}
+
+func (p *paramParser) space() {
+	for p.i < len(p.s) && unicode.IsSpace(p.s[p.i]) {
+		p.i++
+	}
+}
+
+func (p *paramParser) peek() rune {
+	if p.i >= len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+// token reads a quoted string or a bare word.
+func (p *paramParser) token() (string, bool, error) {
+	p.space()
+	start := p.i
+	if p.peek() == '"' {
+		for p.i++; p.i < len(p.s) && p.s[p.i] != '"'; p.i++ {
+			if p.s[p.i] == '\\' {
+				p.i++
+			}
+		}
+		if p.i >= len(p.s) {
+			p.i = start
+			return "", false, p.fail("unterminated string")
+		}
+		p.i++
+		s, err := strconv.Unquote(string(p.s[start:p.i]))
+		if err != nil {
+			p.i = start
+			return "", false, p.fail("invalid string: %s", err)
+		}
+		return s, true, nil
+	}
+	for p.i < len(p.s) && !unicode.IsSpace(p.s[p.i]) && !strings.ContainsRune(`,()="`, p.s[p.i]) {
+		p.i++
+	}
+	if p.i == start {
+		if p.i >= len(p.s) {
+			return "", false, p.fail("missing ')'")
+		}
+		return "", false, p.fail("unexpected '%c'", p.s[p.i])
+	}
+	return string(p.s[start:p.i]), false, nil
+}
+
+// literal returns the typed value of a bare word.
+func literal(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// extractParameters parses a type definition into its name and parameters. Errors are *ParamError.
+func extractParameters(s string) (funcName string, params ParamMap, err error) {
+	pos := strings.Index(s, "(")
+	if pos < 0 {
+		if pos = strings.IndexAny(s, `),="`); pos >= 0 {
+			p := &paramParser{s: []rune(s), i: len([]rune(s[:pos]))}
+			return "", nil, p.fail("unexpected '%c'", p.s[p.i])
+		}
+		return trimString(s), nil, nil
+	}
+	p := &paramParser{s: []rune(s), i: len([]rune(s[:pos])) + 1}
+	params = make(ParamMap)
+	p.space()
+	if p.peek() == ')' {
+		p.i++
+	} else {
+		for {
+			start := p.i
+			key, quoted, err := p.token()
+			if err != nil {
+				return "", nil, err
+			}
+			p.space()
+			switch {
+			case p.peek() == '=' && !quoted:
+				p.i++
+				key = strings.ToLower(key)
+				if _, exists := params[key]; exists {
+					p.i = start
+					return "", nil, p.fail("duplicate parameter '%s'", key)
+				}
+				value, quotedValue, err := p.token()
+				if err != nil {
+					return "", nil, err
+				}
+				if quotedValue {
+					params[key] = value
 				} else {
-					ret[key] = nil
+					params[key] = literal(value)
 				}
+			case quoted:
+				params[paramArgs] = append(params.Args(), key)
+			default:
+				params[key] = nil
+			}
+			p.space()
+			if p.peek() == ',' {
+				p.i++
+				continue
 			}
-			return s[:pos], ret
+			if p.peek() == ')' {
+				p.i++
+				break
+			}
+			if p.i >= len(p.s) {
+				return "", nil, p.fail("missing ')'")
+			}
+			return "", nil, p.fail("expected ',' or ')'")
 		}
 	}
-	return s, nil
+	p.space()
+	if p.i < len(p.s) {
+		return "", nil, p.fail("unexpected '%c' after ')'", p.s[p.i])
+	}
+	return trimString(s[:pos]), params, nil
 }
@@ -9,15 +9,47 @@ var (
 	ErrUnknownType        = errors.New("unknown type")
 	ErrViolationType      = errors.New("data type violates schema")
 	ErrRequired           = errors.New("required")
-	ErrArraySchema        = errors.New("array schema more than one element")
+	ErrArraySchema        = errors.New("array schema has no item type")
 	ErrSchemaType         = errors.New("schema type not matched")
 	ErrSchemaDefType      = errors.New("schema definition is not string")
 	ErrSchemaDefValidator = errors.New("schema definition contains unknown getValidatorFunc type")
+	ErrSchemaDefSyntax    = errors.New("schema definition syntax error")
 	ErrParamType          = errors.New("parameter type error")
 	ErrParamConstraint    = errors.New("parameter constraint failed")
+	ErrPointer            = errors.New("invalid JSON pointer")
+	ErrUnknownKey         = errors.New("unknown key")
+	ErrNoBranch           = errors.New("no alternative matches")
+	ErrAmbiguousBranch    = errors.New("more than one alternative matches")
+	ErrRule               = errors.New("rule failed")
+	ErrRuleType           = errors.New("rule operand type error")
+	ErrTypeRef            = errors.New("invalid type reference")
 )
 
 const (
-	defaultType    = "string"
-	requiredSuffix = "%required"
+	defaultType     = "string"
+	markerSep       = "%"
+	markerRequired  = "required"
+	markerSecret    = "secret"
+	markerStrict    = "strict"
+	markerDefault   = "default"
+	markerNullable  = "nullable"
+	markerOneOf     = "oneOf"
+	markerName      = "name"
+	markerKeys      = "keys"
+	markerRules     = "rules"
+	markerTypes     = "types"
+	markerExtends   = "extends"
+	keyWildcard     = "*"
+	keyPattern      = "~"
+	keyEscape       = "\\"
+	paramDefault    = "default"
+	paramEnum       = "enum"
+	paramIgnoreCase = "ignorecase"
+	paramPattern    = "pattern"
+	paramPrefix     = "prefix"
+	paramSuffix     = "suffix"
+	paramContains   = "contains"
+	paramUnique     = "unique"
+	ruleObject      = "object"
+	ruleArray       = "array"
 )
@@ -1,7 +1,15 @@
 package jsonschema
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/dnsquery"
+	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -78,3 +86,948 @@ func TestJson(t *testing.T) {
 		//	spew.Dump(modifiedData)
 	}
 }
+
+func TestTypeAt(t *testing.T) {
+	var schema interface{}
+	if err := json.Unmarshal([]byte(tdSchema), &schema); err != nil {
+		t.Fatalf("Unmarshal Schema: %s", err)
+	}
+	for _, e := range []struct {
+		path []string
+		typ  string
+		ok   bool
+	}{
+		{[]string{"number"}, "int", true},
+		{[]string{"network", "0", "gateway"}, "ipv4", true},
+		{[]string{"disks", "1"}, "string", true},
+		{[]string{"network", "gateway"}, "", false},
+		{[]string{"messaging"}, "", false},
+		{[]string{"unknown"}, "", false},
+	} {
+		if typ, ok := TypeAt(schema, e.path); typ != e.typ || ok != e.ok {
+			t.Errorf("TypeAt %v: %s %v", e.path, typ, ok)
+		}
+	}
+}
+
+func TestSecret(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{"password%secret":"string","db":{"user":"string","pin":"int%secret%required"}}`), &schema)
+	_ = json.Unmarshal([]byte(`{"password":"hunter2","db":{"user":"admin","pin":1234}}`), &data)
+	_, result, err := ValidateResult(schema, data)
+	if err != nil {
+		t.Fatalf("ValidateResult: %s", err)
+	}
+	secrets := make(map[string]bool)
+	for _, s := range result.Secrets {
+		secrets[s] = true
+	}
+	if !secrets["hunter2"] || !secrets["1234"] || secrets["admin"] {
+		t.Errorf("Wrong secrets: %v", result.Secrets)
+	}
+	if !reflect.DeepEqual(result.SecretPointers, []string{"/db/pin", "/password"}) {
+		t.Errorf("Wrong secret pointers: %v", result.SecretPointers)
+	}
+	// Secret objects and arrays of the wrong type.
+	_ = json.Unmarshal([]byte(`{"db%secret": {"user": "string"}, "keys%secret": ["string"],
+		"auth%secret": {"%oneOf": [{"token": "string"}, {"cert": "string"}]}}`), &schema)
+	_ = json.Unmarshal([]byte(`{"db": "hunter2", "keys": "hunter3", "auth": "hunter4"}`), &data)
+	result = ValidateAll(schema, data)
+	if len(result.Violations) != 3 {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for _, v := range result.Violations {
+		if strings.Contains(v.Error(), "hunter") || strings.Contains(fmt.Sprint(v.Value), "hunter") {
+			t.Errorf("Secret not redacted: %s", v)
+		}
+	}
+	secrets = make(map[string]bool)
+	for _, s := range result.Secrets {
+		secrets[s] = true
+	}
+	if len(secrets) != 3 || !secrets["hunter2"] || !secrets["hunter3"] || !secrets["hunter4"] {
+		t.Errorf("Wrong secrets: %v", result.Secrets)
+	}
+	if name, m := splitMarkers("int(min=1)%secret %required"); name != "int(min=1)" || !m.has(markerSecret) || !m.has(markerRequired) {
+		t.Errorf("Markers not split: %s %v", name, m)
+	}
+	if name, _ := splitMarkers("key%other"); name != "key%other" {
+		t.Errorf("Unknown marker split: %s", name)
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"name%required": "string",
+		"port": "int(min=1)",
+		"network": [{"ipv4": "ipv4net", "gateway": "ipv4%required"}],
+		"zone": "string"
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"port": 0,
+		"network": [{"ipv4": "10.0.0.1/24", "gateway": "x"}, {"ipv4": "x"}],
+		"zone": 1
+	}`), &data)
+	expect := []string{"name", "network[0].gateway", "network[1].gateway", "network[1].ipv4", "port", "zone"}
+	for i := 0; i < 10; i++ {
+		result := ValidateAll(schema, data)
+		if result.Data != nil {
+			t.Error("Data returned for invalid input")
+		}
+		if len(result.Violations) != len(expect) {
+			t.Fatalf("Wrong number of violations: %s", result.Violations)
+		}
+		for j, v := range result.Violations {
+			if PathString(v.Path) != expect[j] {
+				t.Errorf("Violation %d: %s", j, v)
+			}
+		}
+	}
+	errPath, _, err := Validate(schema, data)
+	if err != ErrRequired || len(errPath) != 1 || errPath[0] != "name" {
+		t.Errorf("Validate returned wrong first error: %v %s", errPath, err)
+	}
+}
+
+func TestViolationMessages(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"number": "int(min=1)",
+		"name": "string(max=3)",
+		"network": [{"ipv4": "ipv4net"}],
+		"token%secret": "int",
+		"sub": "object"
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"number": 0,
+		"name": "abcd",
+		"network": [{"ipv4": "10.0.0.300/24"}],
+		"token": "s3cr3t",
+		"sub": "x"
+	}`), &data)
+	expect := []struct {
+		msg, pointer string
+		err          error
+	}{
+		{`name: length 4 is above max=3`, "/name", ErrParamConstraint},
+		{`network[0].ipv4: value "10.0.0.300/24" is not an ipv4net`, "/network/0/ipv4", ErrViolationType},
+		{`number: 0 is below min=1`, "/number", ErrParamConstraint},
+		{`sub: schema definition contains unknown getValidatorFunc type: object`, "/sub", ErrSchemaDefValidator},
+		{`token: value [REDACTED] is not an int`, "/token", ErrViolationType},
+	}
+	result := ValidateAll(schema, data)
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong number of violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i].msg {
+			t.Errorf("Message %d: %s", i, v)
+		}
+		if v.Pointer() != expect[i].pointer {
+			t.Errorf("Pointer %d: %s", i, v.Pointer())
+		}
+		if expect[i].err != nil && !errors.Is(v, expect[i].err) {
+			t.Errorf("Error %d does not wrap %s", i, expect[i].err)
+		}
+	}
+	path, err := PointerPath(schema, "/network/0/ipv4")
+	if err != nil || PathString(path) != "network[0].ipv4" {
+		t.Errorf("PointerPath: %v %s", path, err)
+	}
+	if PointerString([]string{"a/b", "~c", "[2]"}) != "/a~1b/~0c/2" {
+		t.Errorf("PointerString: %s", PointerString([]string{"a/b", "~c", "[2]"}))
+	}
+}
+
+func TestStrict(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"port": "int",
+		"net": {"%strict": true, "gateway": "string", "dns": {"%strict": false, "server": "string"}}
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"port": 1,
+		"extra": 2,
+		"net": {"gatway": "x", "dns": {"server": "y", "search": "z"}}
+	}`), &data)
+	result := ValidateAll(schema, data)
+	if len(result.Violations) != 1 || PathString(result.Violations[0].Path) != "net.gatway" ||
+		!errors.Is(result.Violations[0], ErrUnknownKey) {
+		t.Errorf("Wrong violations: %s", result.Violations)
+	}
+	if len(result.Warnings) != 2 || PathString(result.Warnings[0].Path) != "extra" ||
+		PathString(result.Warnings[1].Path) != "net.dns.search" {
+		t.Errorf("Wrong warnings: %s", result.Warnings)
+	}
+	delete(data.(map[string]interface{})["net"].(map[string]interface{}), "gatway")
+	result = ValidateAll(schema, data)
+	if m, ok := result.Data.(map[string]interface{}); !ok || m["extra"] != 2.0 {
+		t.Errorf("Unknown key not kept in lenient mode: %v", result.Data)
+	}
+	result = ValidateAll(schema, data, Strict(true))
+	if len(result.Violations) != 1 || PathString(result.Violations[0].Path) != "extra" {
+		t.Errorf("Wrong violations in strict mode: %s", result.Violations)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	var schema interface{}
+	_ = json.Unmarshal([]byte(`{
+		"port": "int(min=1,max=30,default=10)",
+		"level": "string%default=Info",
+		"name": "string(default=10)",
+		"set": "int(default=10)",
+		"sub": {"enabled": "string(default=true)"},
+		"bad": "int(max=5,default=10)"
+	}`), &schema)
+	result := ValidateAll(schema, map[string]interface{}{"set": 3.0})
+	if len(result.Violations) != 1 || PathString(result.Violations[0].Path) != "bad" ||
+		!errors.Is(result.Violations[0], ErrParamConstraint) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	delete(schema.(map[string]interface{}), "bad")
+	result = ValidateAll(schema, map[string]interface{}{"set": 3.0})
+	expect := map[string]interface{}{
+		"port":  10,
+		"level": "Info",
+		"name":  "10",
+		"set":   3,
+		"sub":   map[string]interface{}{"enabled": "true"},
+	}
+	if !reflect.DeepEqual(result.Data, expect) {
+		t.Errorf("Wrong data: %#v", result.Data)
+	}
+	data := map[string]interface{}{"wait": "90s", "net": "10.0.0.1/24", "pair": []interface{}{"a"}}
+	schema = map[string]interface{}{
+		"wait":    "duration",
+		"timeout": "duration%default=1m",
+		"net":     "ipv4net",
+		"sub":     map[string]interface{}{"port": "int%default=80"},
+		"pair":    []interface{}{"string", "int%default=2"},
+	}
+	result = ValidateAll(schema, data)
+	if len(result.Violations) != 0 {
+		t.Fatalf("Violations: %s", result.Violations)
+	}
+	if result.Data.(map[string]interface{})["net"] != "10.0.0.1" {
+		t.Errorf("Wrong ipv4net: %v", result.Data)
+	}
+	expect = map[string]interface{}{
+		"wait":    "90s",
+		"timeout": "1m",
+		"net":     "10.0.0.1/24",
+		"sub":     map[string]interface{}{"port": float64(80)},
+		"pair":    []interface{}{"a", float64(2)},
+	}
+	if d := result.WithDefaults(data); !reflect.DeepEqual(d, expect) {
+		t.Errorf("Wrong data with defaults: %#v", d)
+	}
+	if len(data["pair"].([]interface{})) != 1 {
+		t.Error("Data modified")
+	}
+}
+
+func TestBoolNullEnum(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"flag": "bool%required",
+		"flags": ["bool%nullable"],
+		"off": "null",
+		"level": "enum(debug|info|Warn)",
+		"levelAny": "enum(debug|info, ignorecase)",
+		"levelMixed": "enum(debug|info, IgnoreCase)",
+		"size": "enum(1|2|4)",
+		"optional": "string%nullable%required",
+		"net": {"%nullable": true, "gateway": "string%required"},
+		"badLevel": "enum(debug|info)",
+		"badSize": "enum(1|2|4)",
+		"badFlag": "bool",
+		"badOff": "null"
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"flag": false,
+		"flags": [true, null],
+		"level": "Warn",
+		"levelAny": "INFO",
+		"levelMixed": "Debug",
+		"size": 4,
+		"optional": null,
+		"net": null,
+		"badLevel": "Info",
+		"badSize": 3,
+		"badFlag": "true",
+		"badOff": 1
+	}`), &data)
+	result := ValidateAll(schema, data)
+	expect := []string{
+		`badFlag: value "true" is not a bool`,
+		`badLevel: "Info" is not one of debug|info`,
+		`badOff: value 1 is not null`,
+		`badSize: 3 is not one of 1|2|4`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	for _, k := range []string{"badFlag", "badLevel", "badOff", "badSize"} {
+		delete(schema.(map[string]interface{}), k)
+	}
+	result = ValidateAll(schema, data)
+	d, ok := result.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Validation failed: %s", result.Violations)
+	}
+	if d["flag"] != false || d["level"] != "Warn" || d["optional"] != nil || d["net"] != nil ||
+		!reflect.DeepEqual(d["flags"], []interface{}{true, nil}) {
+		t.Errorf("Wrong data: %v", d)
+	}
+}
+
+func TestExtractParameters(t *testing.T) {
+	name, params, err := extractParameters(`regex( Pattern="^a,b\\(c\\)\"$" , min=2, Flag, "arg", x=true, d=10s)`)
+	if err != nil {
+		t.Fatalf("extractParameters: %s", err)
+	}
+	if name != "regex" || params["pattern"] != `^a,b\(c\)"$` || params["min"] != 2.0 || params["x"] != true ||
+		params["d"] != "10s" || !reflect.DeepEqual(params.Args(), []interface{}{"arg"}) ||
+		!reflect.DeepEqual(params.Flags(), []string{"Flag"}) {
+		t.Errorf("Wrong result: %s %#v", name, params)
+	}
+	for s, column := range map[string]int{
+		`string(min=1`:        13,
+		`string(min="1)`:      12,
+		`string(min=1 max=2)`: 14,
+		`string(min=1,min=2)`: 14,
+		`string(min=1) x`:     15,
+		`string(=1)`:          8,
+		`string"`:             7,
+		`regex(pattern="\q")`: 15,
+	} {
+		_, _, err := extractParameters(s)
+		var pErr *ParamError
+		if !errors.As(err, &pErr) || pErr.Column != column || !errors.Is(err, ErrSchemaDefSyntax) {
+			t.Errorf("%s: %v, expected column %d", s, err, column)
+		}
+	}
+}
+
+func TestStringMatch(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"id": "regex(pattern=\"^[a-z]+-[0-9]{2,3}$\")",
+		"host": "suffix(\".example.com\", max=20)",
+		"url": "prefix(prefix=\"https://\")",
+		"list": "contains(\",\")",
+		"badId": "regex(\"^[a-z]+$\")",
+		"badHost": "suffix(\".example.com\", max=15)",
+		"badURL": "prefix(\"https://\")",
+		"badList": "contains(\",\")",
+		"badRegex": "regex(\"(\")"
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"id": "abc-123",
+		"host": "a.example.com",
+		"url": "https://x",
+		"list": "a,b",
+		"badId": "ABC",
+		"badHost": "host.example.com",
+		"badURL": "http://x",
+		"badList": "a;b",
+		"badRegex": "x"
+	}`), &data)
+	expect := []string{
+		`badHost: length 16 is above max=15`,
+		`badId: "ABC" does not match "^[a-z]+$"`,
+		`badList: "a;b" does not contain ","`,
+		"",
+		`badURL: "http://x" does not start with "https://"`,
+	}
+	result := ValidateAll(schema, data)
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if expect[i] != "" && v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	if !errors.Is(result.Violations[3], ErrParamType) {
+		t.Errorf("Invalid regex not reported: %s", result.Violations[3])
+	}
+}
+
+func TestUnion(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"dns": "ipv4|ipv4net",
+		"timeout": "duration|enum(auto)%default=auto",
+		"retries": "int(min=5)|enum(\"never\")",
+		"net": {
+			"mtu": "int",
+			"%oneOf": [
+				{"%name": "static", "address": "ipv4net%required", "gateway": "ipv4"},
+				{"%name": "dhcp", "dhcp": "bool%required"}
+			]
+		},
+		"list": ["ipv4|int"],
+		"badNet": {"%oneOf": [{"a": "string"}, {"b": "string"}]},
+		"noneNet": {"%oneOf": [{"a": "int%required"}, "string"]}
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"dns": "10.0.0.0/8",
+		"retries": 3,
+		"net": {"mtu": 1500, "address": "10.0.0.2/24"},
+		"list": [1, "10.0.0.1"],
+		"badNet": {},
+		"noneNet": {"a": "x"}
+	}`), &data)
+	result := ValidateAll(schema, data)
+	expect := []string{
+		`badNet: object matches 2 alternatives`,
+		`noneNet: object matches none of 0|1 (0: noneNet.a: value "x" is not an int; 1: noneNet: value map[a:x] is not a string)`,
+		`retries: value 3 matches none of int|enum (int: 3 is below min=5; enum: 3 is not one of never)`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	errs := []error{ErrAmbiguousBranch, ErrNoBranch, ErrNoBranch}
+	for i, v := range result.Violations {
+		if !errors.Is(v, errs[i]) {
+			t.Errorf("Violation %d does not wrap %s", i, errs[i])
+		}
+	}
+	m := schema.(map[string]interface{})
+	delete(m, "badNet")
+	delete(m, "noneNet")
+	delete(m, "retries")
+	result = ValidateAll(schema, data)
+	if len(result.Violations) != 0 {
+		t.Fatalf("Unexpected violations: %s", result.Violations)
+	}
+	branches := map[string]string{
+		"/dns":     "ipv4net",
+		"/timeout": "enum",
+		"/net":     "static",
+		"/list/0":  "int",
+		"/list/1":  "ipv4",
+	}
+	if !reflect.DeepEqual(result.Branches, branches) {
+		t.Errorf("Wrong branches: %v", result.Branches)
+	}
+	if d := result.Data.(map[string]interface{}); d["timeout"] != "auto" ||
+		!reflect.DeepEqual(d["net"], map[string]interface{}{"mtu": 1500, "address": "10.0.0.2", "gateway": nil}) {
+		t.Errorf("Wrong data: %v", d)
+	}
+}
+
+func TestMapPatterns(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"users": {
+			"%keys": "regex(\"^[a-z]+$\")",
+			"root": {"uid": "int(max=0)"},
+			"~^svc": {"uid": "int(min=100,max=999)", "shell": "string%default=/sbin/nologin"},
+			"*": {"uid": "int(min=1000)", "shell": "string%default=/bin/sh"}
+		},
+		"hosts": {"*%required": "ipv4"},
+		"globs": {"\\*": "int", "\\~tmp": "bool", "*": "string"}
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"users": {"root": {"uid": 0}, "svcweb": {"uid": 101}, "alice": {"uid": 1000}, "Bob": {"uid": 1001},
+			"bob": {"uid": 1}},
+		"hosts": {},
+		"globs": {"*": "1", "~tmp": true, "x": "y"}
+	}`), &data)
+	result := ValidateAll(schema, data)
+	expect := []string{
+		`globs.*: value "1" is not an int`,
+		`hosts.*: required`,
+		`users.Bob: key: "Bob" does not match "^[a-z]+$"`,
+		`users.bob.uid: 1 is below min=1000`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	if rule := result.Violations[1].Rule; rule != "*%required" {
+		t.Errorf("Wrong rule: %s", rule)
+	}
+	d := data.(map[string]interface{})
+	delete(d["users"].(map[string]interface{}), "Bob")
+	delete(d["users"].(map[string]interface{}), "bob")
+	d["hosts"] = map[string]interface{}{"a": "10.0.0.1"}
+	d["globs"].(map[string]interface{})["*"] = float64(1)
+	result = ValidateAll(schema, data)
+	if globs, _ := result.Data.(map[string]interface{})["globs"].(map[string]interface{}); !reflect.DeepEqual(globs,
+		map[string]interface{}{"*": 1, "~tmp": true, "x": "y"}) {
+		t.Errorf("Wrong globs: %v %s", globs, result.Violations)
+	}
+	users, ok := result.Data.(map[string]interface{})["users"].(map[string]interface{})
+	if !ok || !reflect.DeepEqual(users["svcweb"], map[string]interface{}{"uid": 101, "shell": "/sbin/nologin"}) ||
+		!reflect.DeepEqual(users["alice"], map[string]interface{}{"uid": 1000, "shell": "/bin/sh"}) {
+		t.Errorf("Wrong data: %v %s", result.Data, result.Violations)
+	}
+	if typ, ok := TypeAt(schema, []string{"users", "svcweb", "uid"}); !ok || typ != "int" {
+		t.Errorf("TypeAt: %s %v", typ, ok)
+	}
+}
+
+func TestArrayConstraints(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"nics": ["%array(min=1,max=3,unique=nic)", {"nic": "string%required", "mtu": "int"}],
+		"tags": ["%array(Unique)", "string"],
+		"range": ["int", "int(min=1)%default=10"],
+		"pair": ["string", "bool"],
+		"none": ["%array(min=1)", "int"]
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"nics": [{"nic": "eth0"}, {"nic": "eth1"}, {"nic": "eth0", "mtu": 9000}, {"nic": "eth2"}],
+		"tags": ["a", "b", "a"],
+		"range": [1],
+		"pair": ["x", true, 3],
+		"none": []
+	}`), &data)
+	result := ValidateAll(schema, data)
+	expect := []string{
+		`nics: length 4 is above max=3`,
+		`nics[2]: duplicate nic "eth0"`,
+		`none: length 0 is below min=1`,
+		`pair: length 3 is above max=2`,
+		`tags[2]: duplicate value "a"`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	_ = json.Unmarshal([]byte(`{"range": [1], "pair": ["x"], "tags": ["a"]}`), &data)
+	result = ValidateAll(schema, data)
+	if len(result.Violations) != 0 {
+		t.Fatalf("Violations: %s", result.Violations)
+	}
+	d := result.Data.(map[string]interface{})
+	if !reflect.DeepEqual(d["range"], []interface{}{1, 10}) || !reflect.DeepEqual(d["pair"], []interface{}{"x"}) {
+		t.Errorf("Wrong data: %v", d)
+	}
+	if typ, ok := TypeAt(schema, []string{"range", "1"}); !ok || typ != "int" {
+		t.Errorf("TypeAt: %s %v", typ, ok)
+	}
+	if typ, ok := TypeAt(schema, []string{"pair", "1"}); !ok || typ != "bool" {
+		t.Errorf("TypeAt: %s %v", typ, ok)
+	}
+	if _, ok := TypeAt(schema, []string{"pair", "2"}); ok {
+		t.Error("TypeAt: position beyond tuple")
+	}
+	if result := ValidateAll([]interface{}{"%array(min=x)", "int"}, []interface{}{1}); len(result.Violations) != 1 ||
+		!errors.Is(result.Violations[0].Err, ErrParamType) {
+		t.Errorf("Wrong violations: %s", result.Violations)
+	}
+}
+
+func TestRules(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"net": {
+			"ipv4": "ipv4net",
+			"gateway": "ipv4",
+			"%rules": ["within(gateway, ipv4)"]
+		},
+		"pool": {
+			"min_conn": "int%default=1",
+			"max_conn": "int",
+			"tls": "bool",
+			"tls_cert": "string",
+			"mode": "string",
+			"%rules": [
+				"max_conn >= min_conn",
+				"!tls || has(tls_cert)",
+				"mode in [\"fast\", \"safe\"] && /net/gateway != \"10.0.0.254\""
+			]
+		}
+	}`), &schema)
+	_ = json.Unmarshal([]byte(`{
+		"net": {"ipv4": "10.0.0.1/24", "gateway": "10.0.1.1"},
+		"pool": {"max_conn": 0, "tls": true, "mode": "slow"}
+	}`), &data)
+	result := ValidateAll(schema, data)
+	expect := []string{
+		`net: rule "within(gateway, ipv4)" failed`,
+		`pool: rule "max_conn >= min_conn" failed`,
+		`pool: rule "!tls || has(tls_cert)" failed`,
+		`pool: rule "mode in [\"fast\", \"safe\"] && /net/gateway != \"10.0.0.254\"" failed`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] || !errors.Is(v.Err, ErrRule) {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	// Rules with missing values are satisfied.
+	_ = json.Unmarshal([]byte(`{
+		"net": {"ipv4": "10.0.0.1/24", "gateway": "10.0.0.2"},
+		"pool": {"tls": false}
+	}`), &data)
+	if result := ValidateAll(schema, data); len(result.Violations) != 0 {
+		t.Errorf("Violations: %s", result.Violations)
+	}
+	// within() reads networks as written, also defaults and JSON pointers, the validated data is the address.
+	_ = json.Unmarshal([]byte(`{
+		"net": {"ipv4": "ipv4net%default=192.168.1.1/24", "gateway": "ipv4"},
+		"dmz": {"gateway": "ipv4", "%rules": ["within(gateway, /net/ipv4)"]}
+	}`), &schema)
+	for gateway, ok := range map[string]bool{"192.168.1.9": true, "192.168.2.9": false} {
+		data = map[string]interface{}{"net": map[string]interface{}{}, "dmz": map[string]interface{}{"gateway": gateway}}
+		result := ValidateAll(schema, data)
+		if (len(result.Violations) == 0) != ok {
+			t.Errorf("Gateway %s: %s", gateway, result.Violations)
+		}
+		if ok && result.Data.(map[string]interface{})["net"].(map[string]interface{})["ipv4"] != "192.168.1.1" {
+			t.Errorf("Wrong data: %v", result.Data)
+		}
+	}
+	for _, test := range []struct {
+		rule string
+		err  error
+	}{
+		{`a == 1 || b`, ErrRuleType},
+		{`a < "x"`, ErrRuleType},
+		{`len(a) == 1`, ErrRuleType},
+		{`a ==`, ErrSchemaDefSyntax},
+		{`has(1)`, ErrSchemaDefSyntax},
+		{`foo(a)`, ErrSchemaDefSyntax},
+		{`(a == 1`, ErrSchemaDefSyntax},
+	} {
+		result := ValidateAll(map[string]interface{}{"a": "int", "b": "string", "%rules": []interface{}{test.rule}},
+			map[string]interface{}{"a": 2, "b": "x"})
+		if len(result.Violations) != 1 || !errors.Is(result.Violations[0].Err, test.err) {
+			t.Errorf("%s: %s", test.rule, result.Violations)
+		}
+	}
+	// Comparisons with missing or null values are unknown.
+	for _, test := range []struct {
+		rule  string
+		valid bool
+	}{
+		{`c == 1`, true},
+		{`c != 1`, true},
+		{`c == null`, true},
+		{`a in [1, c]`, true},
+		{`a in [1, 3]`, false},
+		{`n == null`, true},
+		{`n != 2`, true},
+		{`a != 2`, false},
+	} {
+		result := ValidateAll(map[string]interface{}{"a": "int", "c": "int", "n": "int%nullable",
+			"%rules": []interface{}{test.rule}}, map[string]interface{}{"a": 2, "n": nil})
+		if valid := len(result.Violations) == 0; valid != test.valid {
+			t.Errorf("%s: %s", test.rule, result.Violations)
+		}
+	}
+	// Errors of rules that read secret values do not contain the values.
+	for _, rule := range []string{`len(a) == 1`, `a < "x"`, `within(a, "10.0.0.0/8")`, `a`} {
+		result := ValidateAll(map[string]interface{}{"a": "int%secret", "%rules": []interface{}{rule}},
+			map[string]interface{}{"a": 12345})
+		if len(result.Violations) != 1 || !errors.Is(result.Violations[0].Err, ErrRuleType) ||
+			strings.Contains(result.Violations[0].Error(), "12345") {
+			t.Errorf("%s: %s", rule, result.Violations)
+		}
+	}
+}
+
+func TestNamedTypes(t *testing.T) {
+	var schema, data interface{}
+	_ = json.Unmarshal([]byte(`{
+		"%types": {
+			"port": "int(min=1,max=65535)",
+			"listen": "port|enum(auto)",
+			"iface": {"name": "string%required", "mtu": "int%default=1500"},
+			"tree": {"name": "string", "children": ["tree"], "parent": "tree"},
+			"static": {"address": "ipv4net%required"},
+			"dhcp": {"dhcp": "bool%required"}
+		},
+		"http": "port%required",
+		"admin": "listen",
+		"nics": ["iface"],
+		"uplink": "iface",
+		"tree": "tree",
+		"addr": {"%oneOf": ["static", "dhcp"]},
+		"local": {
+			"%types": {"port": "int(min=1024,max=65535)"},
+			"http": "port",
+			"ext": "common.json#ext"
+		}
+	}`), &schema)
+	// References are relative to the file that contains them.
+	files := map[string]string{
+		"schemas/common.json":    `{"%types": {"ext": {"port": "port", "nic": "net/iface.json#name"}, "port": "int(max=10)"}}`,
+		"schemas/net/iface.json": `{"%types": {"name": "enum(eth0|eth1)"}}`,
+	}
+	loads := make(map[string]int)
+	options := []Option{SchemaFile("schemas/main.json"), Loader(func(name string) (interface{}, error) {
+		loads[name]++
+		f, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		var schema interface{}
+		err := json.Unmarshal([]byte(f), &schema)
+		return schema, err
+	})}
+	_ = json.Unmarshal([]byte(`{
+		"http": 80,
+		"admin": "auto",
+		"nics": [{"name": "eth0"}, {"mtu": 9000}],
+		"uplink": {"name": "eth1"},
+		"tree": {"name": "a", "children": [{"name": "b", "children": [{"name": "c", "parent": {"name": 1}}]}]},
+		"addr": {"dhcp": true},
+		"local": {"http": 80, "ext": {"port": 11, "nic": "eth2"}}
+	}`), &data)
+	result := ValidateAll(schema, data, options...)
+	expect := []string{
+		`local.ext.nic: "eth2" is not one of eth0|eth1`,
+		`local.ext.port: 11 is above max=10`,
+		`local.http: 80 is below min=1024`,
+		`nics[1].name: required`,
+		`tree.children[0].children[0].parent.name: value 1 is not a string`,
+	}
+	if len(result.Violations) != len(expect) {
+		t.Fatalf("Wrong violations: %s", result.Violations)
+	}
+	for i, v := range result.Violations {
+		if v.Error() != expect[i] {
+			t.Errorf("Violation %d: %s", i, v)
+		}
+	}
+	if result.Branches["/addr"] != "dhcp" || result.Branches["/admin"] != "enum" {
+		t.Errorf("Wrong branches: %v", result.Branches)
+	}
+	if !reflect.DeepEqual(loads, map[string]int{"schemas/common.json": 1, "schemas/net/iface.json": 1}) {
+		t.Errorf("Wrong loads: %v", loads)
+	}
+	if refs := FileRefs(schema); !reflect.DeepEqual(refs, []string{"common.json#ext"}) {
+		t.Errorf("FileRefs: %v", refs)
+	}
+	_ = json.Unmarshal([]byte(`{"http": 80, "nics": [{"name": "eth0"}], "uplink": {"name": "eth1"}}`), &data)
+	result = ValidateAll(schema, data, options...)
+	if len(result.Violations) != 0 {
+		t.Fatalf("Violations: %s", result.Violations)
+	}
+	d := result.Data.(map[string]interface{})
+	if !reflect.DeepEqual(d["nics"], []interface{}{map[string]interface{}{"name": "eth0", "mtu": 1500}}) {
+		t.Errorf("Wrong data: %v", d)
+	}
+	if typ, ok := TypeAt(schema, []string{"nics", "0", "mtu"}); !ok || typ != "int" {
+		t.Errorf("TypeAt: %s %v", typ, ok)
+	}
+	if typ, ok := TypeAt(schema, []string{"local", "ext", "nic"}, options...); !ok || typ != "enum" {
+		t.Errorf("TypeAt: %s %v", typ, ok)
+	}
+	if path, err := PointerPath(schema, "/tree/children/0/name"); err != nil || PathString(path) != "tree.children[0].name" {
+		t.Errorf("PointerPath: %v %s", path, err)
+	}
+	for _, test := range []struct {
+		schema string
+		err    error
+	}{
+		{`{"%types": {"a": "b", "b": "a"}, "x": "a"}`, ErrTypeRef},
+		{`{"%types": {"int": "string"}, "x": "int"}`, ErrSchemaDefSyntax},
+		{`{"%types": {"o": {}}, "x": "o|int"}`, ErrTypeRef},
+		{`{"x": "missing.json#a"}`, ErrTypeRef},
+	} {
+		_ = json.Unmarshal([]byte(test.schema), &schema)
+		result := ValidateAll(schema, map[string]interface{}{"x": 1})
+		if len(result.Violations) != 1 || !errors.Is(result.Violations[0].Err, test.err) {
+			t.Errorf("%s: %s", test.schema, result.Violations)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	var parent, child interface{}
+	_ = json.Unmarshal([]byte(`{
+		"%types": {"port": "int(min=1)", "name": "string"},
+		"%rules": ["a < b"],
+		"name": "string",
+		"port": "port",
+		"net%secret": {"gateway": "ipv4", "mtu": "int"},
+		"list": ["int"]
+	}`), &parent)
+	_ = json.Unmarshal([]byte(`{
+		"%extends": true,
+		"%types": {"port": "int(min=1024)"},
+		"%rules": ["b < c"],
+		"name%required": "string",
+		"net": {"mtu": "int(max=9000)", "dns": "ipv4"},
+		"list": ["string"],
+		"extra": "bool"
+	}`), &child)
+	if !Extends(child) || Extends(parent) {
+		t.Error("Extends")
+	}
+	var expect interface{}
+	_ = json.Unmarshal([]byte(`{
+		"%types": {"port": "int(min=1024)", "name": "string"},
+		"%rules": ["a < b", "b < c"],
+		"name%required": "string",
+		"port": "port",
+		"net%secret": {"gateway": "ipv4", "mtu": "int(max=9000)", "dns": "ipv4"},
+		"list": ["string"],
+		"extra": "bool"
+	}`), &expect)
+	if got := Extend(parent, child); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Extend: %v", got)
+	}
+	if len(parent.(map[string]interface{})) != 6 || len(parent.(map[string]interface{})["%rules"].([]interface{})) != 1 {
+		t.Errorf("Parent modified: %v", parent)
+	}
+	if got := Extend(nil, child); !Extends(child) || Extends(got) || len(got.(map[string]interface{})) != 6 {
+		t.Errorf("Extend without parent: %v", got)
+	}
+	// Markers of replaced definitions are kept.
+	_ = json.Unmarshal([]byte(`{
+		"password": "string%secret",
+		"port": "int%required",
+		"proxy": "string%nullable",
+		"user": "string%required"
+	}`), &parent)
+	_ = json.Unmarshal([]byte(`{
+		"%extends": true,
+		"password": "string(min=3)",
+		"port": "int(min=1024)",
+		"proxy%required": "hostname",
+		"user": {"name": "string"}
+	}`), &child)
+	schema := Extend(parent, child)
+	data := map[string]interface{}{"password": "hunter2", "proxy": nil}
+	result := ValidateAll(schema, data)
+	if len(result.Secrets) == 0 || result.Secrets[0] != "hunter2" {
+		t.Errorf("Secret dropped: %v", result.Secrets)
+	}
+	if len(result.Violations) != 2 || result.Violations[0].Error() != `port: `+ErrRequired.Error() ||
+		result.Violations[1].Error() != `user: `+ErrRequired.Error() {
+		t.Errorf("Wrong violations: %s", result.Violations)
+	}
+	data = map[string]interface{}{"password": "x", "port": float64(80), "proxy": nil, "user": map[string]interface{}{}}
+	if result := ValidateAll(schema, data); len(result.Violations) != 2 ||
+		!reflect.DeepEqual(result.Violations[0].Value, redacted{}) {
+		t.Errorf("Wrong violations: %s", result.Violations)
+	}
+}
+
+// stubResolver has records for example.com and 192.0.2.1, none for empty.example.com. Other names do not exist.
+type stubResolver struct{}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (stubResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	switch name {
+	case "_ldap._tcp.example.com":
+		return name, []*net.SRV{{Target: "ldap.example.com.", Port: 389}}, nil
+	case "empty.example.com":
+		return name, nil, nil
+	}
+	return "", nil, notFound(name)
+}
+
+func (stubResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	switch name {
+	case "example.com":
+		return []*net.MX{{Host: "mx.example.com.", Pref: 10}}, nil
+	case "empty.example.com":
+		return nil, nil
+	}
+	return nil, notFound(name)
+}
+
+func (stubResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	switch host {
+	case "www.example.com":
+		return "example.com.", nil
+	case "empty.example.com":
+		return host + ".", nil
+	}
+	return "", notFound(host)
+}
+
+func (stubResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if addr != "192.0.2.1" {
+		return nil, notFound(addr)
+	}
+	return []string{"host.example.com."}, nil
+}
+
+func (stubResolver) LookupNS(_ context.Context, name string) ([]*net.NS, error) {
+	switch name {
+	case "example.com":
+		return []*net.NS{{Host: "ns1.example.com."}}, nil
+	case "empty.example.com":
+		return nil, nil
+	}
+	return nil, notFound(name)
+}
+
+func TestDNSValidators(t *testing.T) {
+	defer func(r dnsResolver, caa func(string) ([]dnsquery.CAA, error)) { resolver, queryCAA = r, caa }(resolver, queryCAA)
+	resolver = stubResolver{}
+	queryCAA = func(name string) ([]dnsquery.CAA, error) {
+		switch name {
+		case "example.com":
+			return []dnsquery.CAA{{Tag: "issue", Value: "ca.example.com"}}, nil
+		case "empty.example.com":
+			return nil, nil
+		}
+		return nil, notFound(name)
+	}
+	for _, test := range []struct {
+		validator, value string
+		valid            bool
+	}{
+		{"lookupsrv", "_ldap._tcp.example.com", true},
+		{"lookupsrv", "empty.example.com", false},
+		{"lookupsrv", "_x._tcp.missing.example.com", false},
+		{"lookupmx", "example.com", true},
+		{"lookupmx", "empty.example.com", false},
+		{"lookupmx", "missing.example.com", false},
+		{"lookupcname", "www.example.com", true},
+		{"lookupcname", "empty.example.com", false},
+		{"lookupcname", "missing.example.com", false},
+		{"lookupptr", "192.0.2.1", true},
+		{"lookupptr", "192.0.2.2", false},
+		{"lookupns", "example.com", true},
+		{"lookupns", "empty.example.com", false},
+		{"lookupns", "missing.example.com", false},
+		{"lookupcaa", "example.com", true},
+		{"lookupcaa", "empty.example.com", false},
+		{"lookupcaa", "missing.example.com", false},
+	} {
+		result := ValidateAll(map[string]interface{}{"x": test.validator}, map[string]interface{}{"x": test.value})
+		if valid := len(result.Violations) == 0; valid != test.valid {
+			t.Errorf("%s %s: %s", test.validator, test.value, result.Violations)
+		}
+		if !test.valid && len(result.Violations) == 1 && !errors.Is(result.Violations[0].Err, ErrViolationType) {
+			t.Errorf("%s %s: wrong error %s", test.validator, test.value, result.Violations[0].Err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package jsonschema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Maps with arbitrary keys:
+//
+//	"*": Validates the values of all keys that are not named in the schema.
+//	"~^[a-z]+$": Validates the values of keys matching the regular expression. Patterns are tried in order of their
+//	keys, before "*".
+//	"%keys": "regex(\"^[a-z]+$\")": Validates the names of all keys that are not named in the schema.
+//
+// A "%required" marker on a pattern requires at least one matching key. Keys that start with "*", "~" or "%" are
+// written with a leading backslash, "\\*" in JSON is the key "*".
+
+type mapPattern struct {
+	key     string         // Schema key.
+	name    string         // Key without markers.
+	markers markers        // Markers of the key.
+	re      *regexp.Regexp // Nil for "*".
+}
+
+// keyName returns the data key of a named schema key and its markers.
+func keyName(key string) (string, markers) {
+	name, m := splitMarkers(key)
+	return strings.TrimPrefix(name, keyEscape), m
+}
+
+func isPattern(key string) bool {
+	name, _ := splitMarkers(key)
+	return name == keyWildcard || strings.HasPrefix(name, keyPattern)
+}
+
+// mapPatterns returns the pattern keys of schema, regular expressions first.
+func mapPatterns(schema map[string]interface{}) ([]*mapPattern, error) {
+	var ret []*mapPattern
+	for _, key := range sortedKeys(schema) {
+		if isDirective(key) || !isPattern(key) {
+			continue
+		}
+		name, m := splitMarkers(key)
+		p := &mapPattern{key: key, name: name, markers: m}
+		if name != keyWildcard {
+			re, err := compileRegex(name[len(keyPattern):])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", name, err, ErrSchemaDefSyntax)
+			}
+			p.re = re
+		}
+		ret = append(ret, p)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].re != nil && ret[j].re == nil
+	})
+	return ret, nil
+}
+
+// matchPattern returns the index of the first pattern that matches key, or -1.
+func matchPattern(patterns []*mapPattern, key string) int {
+	for i, p := range patterns {
+		if p.re == nil || p.re.MatchString(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupKey returns the schema of key in a map schema. Named keys take precedence over patterns.
+func lookupKey(schema map[string]interface{}, key string) (interface{}, bool) {
+	for k, v := range schema {
+		if isDirective(k) || isPattern(k) {
+			continue
+		}
+		if name, _ := keyName(k); name == key {
+			return v, true
+		}
+	}
+	patterns, err := mapPatterns(schema)
+	if err != nil {
+		return nil, false
+	}
+	if i := matchPattern(patterns, key); i >= 0 {
+		return schema[patterns[i].key], true
+	}
+	return nil, false
+}
@@ -0,0 +1,690 @@
+package jsonschema
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rules check constraints that span fields. They are listed in "%rules" of a map and evaluated after all fields
+// have been validated, on the validated data including defaults:
+//
+//	"%rules": ["max_conn >= min_conn", "!tls || has(tls_cert)", "within(gateway, ipv4)"]
+//
+// Operands are literals (numbers, "strings", true, false, null), lists ([1, 2]), paths relative to the map
+// (net.gateway, nics[0].name) and JSON pointers from the root of the data (/network/0/ipv4). Operators are
+// ==, !=, <, <=, >, >=, in, !, && and || with the usual precedence. Functions are has(path), len(value) and
+// within(address, network). Paths given to within() read the values as written, so that an ipv4net keeps its
+// prefix length.
+//
+// Like SQL check constraints, a comparison with a missing value is unknown and a rule that evaluates to unknown is
+// satisfied. Null values are missing, also for == and !=. has() makes rules require values.
+//
+// Errors do not contain values if the rule reads a secret value.
+
+// unknown is the result of comparisons with missing values.
+type unknown struct{}
+
+type ruleNode interface {
+	eval(c *ruleContext) (interface{}, error)
+}
+
+// ruleContext contains the data rules are evaluated on.
+type ruleContext struct {
+	local   map[string]interface{}
+	path    []string // Path of local.
+	root    interface{}
+	written interface{}     // Root of the data as written, with defaults.
+	secrets map[string]bool // JSON pointers of secret values.
+	secret  bool            // A secret value has been read.
+}
+
+// rule is a parsed rule of a map, evaluated when validation has finished.
+type rule struct {
+	path  []string
+	expr  string
+	node  ruleNode
+	local map[string]interface{}
+}
+
+// parseRules parses the "%rules" of a map schema.
+func parseRules(rules interface{}) ([]*rule, error) {
+	list, ok := rules.([]interface{})
+	if !ok {
+		return nil, ErrSchemaDefType
+	}
+	ret := make([]*rule, 0, len(list))
+	for _, e := range list {
+		s, ok := e.(string)
+		if !ok {
+			return nil, ErrSchemaDefType
+		}
+		node, err := parseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", s, err)
+		}
+		ret = append(ret, &rule{expr: s, node: node})
+	}
+	return ret, nil
+}
+
+// check evaluates the rule. Returns a *RuleError if it is not satisfied.
+func (r *rule) check(root, written interface{}, secrets map[string]bool) error {
+	c := &ruleContext{local: r.local, path: r.path, root: root, written: written, secrets: secrets}
+	value, err := r.node.eval(c)
+	if err == nil {
+		switch value.(type) {
+		case unknown:
+			return nil
+		case bool:
+			if value.(bool) {
+				return nil
+			}
+			return &RuleError{Rule: r.expr}
+		}
+		err = fmt.Errorf("%w: result is %s", ErrRuleType, describeValue(value))
+	}
+	if c.secret {
+		// Causes are dropped since they may contain the value.
+		err = ErrRuleType
+	}
+	return &RuleError{Rule: r.expr, Err: err}
+}
+
+type ruleParser struct {
+	paramParser
+}
+
+func parseRule(s string) (ruleNode, error) {
+	p := &ruleParser{paramParser{s: []rune(s)}}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.i < len(p.s) {
+		return nil, p.fail("unexpected '%c'", p.s[p.i])
+	}
+	return node, nil
+}
+
+// consume skips op if it is next.
+func (p *ruleParser) consume(op string) bool {
+	p.space()
+	if strings.HasPrefix(string(p.s[p.i:]), op) {
+		p.i += len([]rune(op))
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) expect(op string) error {
+	if !p.consume(op) {
+		return p.fail("expected '%s'", op)
+	}
+	return nil
+}
+
+func (p *ruleParser) or() (ruleNode, error) {
+	left, err := p.and()
+	for err == nil && p.consume("||") {
+		var right ruleNode
+		if right, err = p.and(); err == nil {
+			left = &logicalNode{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) and() (ruleNode, error) {
+	left, err := p.not()
+	for err == nil && p.consume("&&") {
+		var right ruleNode
+		if right, err = p.not(); err == nil {
+			left = &logicalNode{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) not() (ruleNode, error) {
+	if p.consume("!") {
+		if p.peek() == '=' {
+			return nil, p.fail("unexpected '='")
+		}
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+	return p.comparison()
+}
+
+func (p *ruleParser) comparison() (ruleNode, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			right, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			return &compareNode{op: op, left: left, right: right}, nil
+		}
+	}
+	p.space()
+	start := p.i
+	if word := p.word(); word == "in" {
+		right, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{left: left, right: right}, nil
+	}
+	p.i = start
+	return left, nil
+}
+
+// word reads an identifier.
+func (p *ruleParser) word() string {
+	start := p.i
+	for p.i < len(p.s) && (unicode.IsLetter(p.s[p.i]) || unicode.IsDigit(p.s[p.i]) || p.s[p.i] == '_' || p.s[p.i] == '-') {
+		p.i++
+	}
+	return string(p.s[start:p.i])
+}
+
+func (p *ruleParser) primary() (ruleNode, error) {
+	p.space()
+	start := p.i
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, p.fail("unexpected end of rule")
+	case c == '(':
+		p.i++
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case c == '[':
+		p.i++
+		list := new(listNode)
+		if p.consume("]") {
+			return list, nil
+		}
+		for {
+			node, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, node)
+			if p.consume("]") {
+				return list, nil
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	case c == '"':
+		s, _, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		return &literalNode{value: s}, nil
+	case c == '/':
+		for p.i < len(p.s) && !unicode.IsSpace(p.s[p.i]) && !strings.ContainsRune(`(),[]!=<>&|`, p.s[p.i]) {
+			p.i++
+		}
+		path, err := pointerElements(string(p.s[start:p.i]))
+		if err != nil {
+			p.i = start
+			return nil, p.fail("invalid JSON pointer")
+		}
+		return &pathNode{root: true, path: path}, nil
+	case c == '-' || c == '.' || unicode.IsDigit(c):
+		for p.i < len(p.s) && strings.ContainsRune("+-.eE0123456789", p.s[p.i]) {
+			p.i++
+		}
+		f, err := strconv.ParseFloat(string(p.s[start:p.i]), 64)
+		if err != nil {
+			p.i = start
+			return nil, p.fail("invalid number")
+		}
+		return &literalNode{value: f}, nil
+	}
+	word := p.word()
+	switch word {
+	case "":
+		return nil, p.fail("unexpected '%c'", p.peek())
+	case "true", "false":
+		return &literalNode{value: word == "true"}, nil
+	case "null":
+		return &literalNode{}, nil
+	}
+	if p.consume("(") {
+		return p.function(word, start)
+	}
+	return p.path(word)
+}
+
+// path reads the remainder of a relative path starting with name.
+func (p *ruleParser) path(name string) (ruleNode, error) {
+	node := &pathNode{path: []string{name}}
+	for {
+		switch p.peek() {
+		case '.':
+			p.i++
+			name := p.word()
+			if name == "" {
+				return nil, p.fail("expected field name")
+			}
+			node.path = append(node.path, name)
+		case '[':
+			p.i++
+			start := p.i
+			for p.i < len(p.s) && unicode.IsDigit(p.s[p.i]) {
+				p.i++
+			}
+			if p.i == start || p.peek() != ']' {
+				return nil, p.fail("expected index")
+			}
+			node.path = append(node.path, string(p.s[start:p.i]))
+			p.i++
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *ruleParser) function(name string, start int) (ruleNode, error) {
+	var args []ruleNode
+	if !p.consume(")") {
+		for {
+			node, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, node)
+			if p.consume(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	arity := map[string]int{"has": 1, "len": 1, "within": 2}
+	n, ok := arity[name]
+	if !ok {
+		p.i = start
+		return nil, p.fail("unknown function '%s'", name)
+	}
+	if len(args) != n {
+		p.i = start
+		return nil, p.fail("%s needs %d arguments", name, n)
+	}
+	if name == "has" {
+		path, ok := args[0].(*pathNode)
+		if !ok {
+			p.i = start
+			return nil, p.fail("has needs a path")
+		}
+		return &hasNode{path: path}, nil
+	}
+	return &funcNode{name: name, args: args}, nil
+}
+
+// pointerElements splits a JSON pointer into its unescaped elements.
+func pointerElements(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrPointer
+	}
+	elements := strings.Split(pointer[1:], "/")
+	for i, e := range elements {
+		elements[i] = strings.ReplaceAll(strings.ReplaceAll(e, "~1", "/"), "~0", "~")
+	}
+	return elements, nil
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(*ruleContext) (interface{}, error) {
+	return n.value, nil
+}
+
+type listNode struct {
+	items []ruleNode
+}
+
+func (n *listNode) eval(c *ruleContext) (interface{}, error) {
+	ret := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = value
+	}
+	return ret, nil
+}
+
+type pathNode struct {
+	root bool
+	path []string
+}
+
+// lookup returns the value at the path, false if it is missing.
+func (n *pathNode) lookup(c *ruleContext) (interface{}, bool) {
+	if n.root {
+		return lookupPath(c.root, n.path)
+	}
+	return lookupPath(c.local, n.path)
+}
+
+// lookupWritten returns the value at the path in the data as written, false if it is missing.
+func (n *pathNode) lookupWritten(c *ruleContext) (interface{}, bool) {
+	if n.root {
+		return lookupPath(c.written, n.path)
+	}
+	return lookupPath(c.written, append(append([]string{}, c.path...), n.path...))
+}
+
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	for _, e := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[e]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(e)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// isSecret returns true if the value at the path is secret or below a secret value.
+func (n *pathNode) isSecret(c *ruleContext) bool {
+	path := n.path
+	if !n.root {
+		path = append(append([]string{}, c.path...), n.path...)
+	}
+	for p := PointerString(path); ; p = p[:strings.LastIndex(p, "/")] {
+		if c.secrets[p] {
+			return true
+		}
+		if p == "" {
+			return false
+		}
+	}
+}
+
+func (n *pathNode) eval(c *ruleContext) (interface{}, error) {
+	value, ok := n.lookup(c)
+	if !ok || value == nil {
+		return unknown{}, nil
+	}
+	if n.isSecret(c) {
+		c.secret = true
+	}
+	return value, nil
+}
+
+type hasNode struct {
+	path *pathNode
+}
+
+func (n *hasNode) eval(c *ruleContext) (interface{}, error) {
+	value, ok := n.path.lookup(c)
+	return ok && value != nil, nil
+}
+
+type notNode struct {
+	node ruleNode
+}
+
+func (n *notNode) eval(c *ruleContext) (interface{}, error) {
+	value, err := n.node.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch b := value.(type) {
+	case bool:
+		return !b, nil
+	case unknown, nil:
+		return unknown{}, nil
+	}
+	return nil, fmt.Errorf("%w: operand of ! is %s", ErrRuleType, describeValue(value))
+}
+
+type logicalNode struct {
+	op          string
+	left, right ruleNode
+}
+
+// eval implements three-valued logic: false && unknown is false, true || unknown is true.
+func (n *logicalNode) eval(c *ruleContext) (interface{}, error) {
+	var values [2]interface{}
+	for i, node := range []ruleNode{n.left, n.right} {
+		value, err := node.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		switch b := value.(type) {
+		case bool:
+			if b == (n.op == "||") {
+				return b, nil
+			}
+		case unknown, nil:
+			value = unknown{}
+		default:
+			return nil, fmt.Errorf("%w: operand of %s is %s", ErrRuleType, n.op, describeValue(value))
+		}
+		values[i] = value
+	}
+	if values[0] == (unknown{}) || values[1] == (unknown{}) {
+		return unknown{}, nil
+	}
+	return n.op == "&&", nil
+}
+
+type compareNode struct {
+	op          string
+	left, right ruleNode
+}
+
+func (n *compareNode) eval(c *ruleContext) (interface{}, error) {
+	left, err := n.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if left == (unknown{}) || right == (unknown{}) {
+		return unknown{}, nil
+	}
+	switch n.op {
+	case "==":
+		return ruleEqual(left, right), nil
+	case "!=":
+		return !ruleEqual(left, right), nil
+	}
+	if left == nil || right == nil {
+		return unknown{}, nil
+	}
+	var cmp int
+	lf, lok := ruleNumber(left)
+	rf, rok := ruleNumber(right)
+	ls, lsok := left.(string)
+	rs, rsok := right.(string)
+	switch {
+	case lok && rok:
+		cmp = compareFloat(lf, rf)
+	case lsok && rsok:
+		cmp = strings.Compare(ls, rs)
+	default:
+		return nil, fmt.Errorf("%w: cannot compare %s and %s", ErrRuleType, describeValue(left), describeValue(right))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type inNode struct {
+	left, right ruleNode
+}
+
+func (n *inNode) eval(c *ruleContext) (interface{}, error) {
+	left, err := n.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || left == (unknown{}) || right == nil || right == (unknown{}) {
+		return unknown{}, nil
+	}
+	switch r := right.(type) {
+	case []interface{}:
+		var missing bool
+		for _, e := range r {
+			if e == (unknown{}) {
+				missing = true
+			} else if ruleEqual(left, e) {
+				return true, nil
+			}
+		}
+		if missing {
+			return unknown{}, nil
+		}
+		return false, nil
+	case map[string]interface{}:
+		if s, ok := left.(string); ok {
+			_, found := r[s]
+			return found, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: cannot look up %s in %s", ErrRuleType, describeValue(left), describeValue(right))
+}
+
+type funcNode struct {
+	name string
+	args []ruleNode
+}
+
+func (n *funcNode) eval(c *ruleContext) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, node := range n.args {
+		value, err := node.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil || value == (unknown{}) {
+			return unknown{}, nil
+		}
+		if path, ok := node.(*pathNode); ok && n.name == "within" {
+			if written, ok := path.lookupWritten(c); ok && written != nil {
+				value = written
+			}
+		}
+		args[i] = value
+	}
+	switch n.name {
+	case "len":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("%w: len of %s", ErrRuleType, describeValue(args[0]))
+	default:
+		return within(args[0], args[1])
+	}
+}
+
+// within returns true if address lies within network. Both can be given with prefix length, e.g. 10.0.0.1/24.
+func within(address, network interface{}) (interface{}, error) {
+	a, aok := address.(string)
+	n, nok := network.(string)
+	if !aok || !nok {
+		return nil, fmt.Errorf("%w: within needs strings", ErrRuleType)
+	}
+	ip := net.ParseIP(a)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(a); err != nil {
+			return nil, fmt.Errorf("%w: %s is not an address", ErrRuleType, formatValue(a))
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(n)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is not a network", ErrRuleType, formatValue(n))
+	}
+	return ipnet.Contains(ip), nil
+}
+
+// ruleNumber returns the value of numbers of any type, including durations.
+func ruleNumber(v interface{}) (float64, bool) {
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), true
+	}
+	return 0, false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func ruleEqual(a, b interface{}) bool {
+	af, aok := ruleNumber(a)
+	bf, bok := ruleNumber(b)
+	if aok && bok {
+		return af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
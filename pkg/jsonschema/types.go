@@ -0,0 +1,224 @@
+package jsonschema
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Named types:
+//
+//	"%types": {"port": "int(min=1,max=65535)", "iface": {"name": "string%required", "mtu": "int"}}
+//
+// Types defined in "%types" of a map can be used in the map and below it like validators: "port%required",
+// ["iface"], "port|enum(auto)". Definitions can refer to other types and to themselves. Markers of the reference
+// are added to the definition. "file.json#iface" refers to a type of another schema file, "file.json#" to the
+// whole schema of the file. Files are loaded by the SchemaLoader of the validation, relative names are relative to the
+// directory of the schema file that contains the reference.
+
+// maxTypeRefs limits the number of references followed to resolve a type, to detect cycles of aliases.
+const maxTypeRefs = 64
+
+// SchemaLoader loads the schema file name, as referred to by "name#type". Relative names have been joined with the
+// directory of the referring schema file.
+type SchemaLoader func(name string) (interface{}, error)
+
+// schemaFiles loads the schema files of a validation. Each file is loaded once.
+type schemaFiles struct {
+	loader SchemaLoader
+	cache  map[string]interface{} // Schemas by file name as passed to the loader.
+}
+
+// load returns the schema of the file name, relative to the directory of the schema file from.
+func (f *schemaFiles) load(from, name string) (string, interface{}, error) {
+	if !path.IsAbs(name) {
+		name = path.Join(path.Dir(from), name)
+	}
+	if schema, ok := f.cache[name]; ok {
+		return name, schema, nil
+	}
+	if f.loader == nil {
+		return "", nil, fmt.Errorf("%w: %s: schema files cannot be loaded", ErrTypeRef, name)
+	}
+	schema, err := f.loader(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s: %s", ErrTypeRef, name, err)
+	}
+	f.cache[name] = schema
+	return name, schema, nil
+}
+
+// typeScope contains the named types visible in a part of the schema.
+type typeScope struct {
+	types  map[string]interface{}
+	parent *typeScope
+	files  *schemaFiles
+	file   string // Schema file that contains the types, "" if unknown.
+}
+
+// newScope returns the scope of the top of a schema, without types.
+func newScope() *typeScope {
+	return &typeScope{files: &schemaFiles{cache: make(map[string]interface{})}}
+}
+
+// with returns the scope of schema, which adds its "%types" to s.
+func (s *typeScope) with(schema map[string]interface{}) (*typeScope, error) {
+	types, ok := schema[markerSep+markerTypes]
+	if !ok {
+		return s, nil
+	}
+	m, ok := types.(map[string]interface{})
+	if !ok {
+		return s, ErrSchemaDefType
+	}
+	for name := range m {
+		if _, ok := validatorFuncMap[name]; ok {
+			return s, fmt.Errorf("%w: type %s shadows validator", ErrSchemaDefSyntax, name)
+		}
+		if name == "" || strings.ContainsAny(name, markerSep+"#|()=\", ") {
+			return s, fmt.Errorf("%w: invalid type name %q", ErrSchemaDefSyntax, name)
+		}
+	}
+	return &typeScope{types: m, parent: s, files: s.files, file: s.file}, nil
+}
+
+// lookup returns the definition of the type name and the scope it is defined in.
+func (s *typeScope) lookup(name string) (interface{}, *typeScope, bool, error) {
+	if strings.ContainsAny(name, "(|\"") {
+		return nil, nil, false, nil
+	}
+	if i := strings.Index(name, "#"); i >= 0 {
+		file, schema, err := s.files.load(s.file, name[:i])
+		if err != nil {
+			return nil, nil, false, err
+		}
+		root := &typeScope{files: s.files, file: file}
+		if m, ok := schema.(map[string]interface{}); ok {
+			if root, err = root.with(m); err != nil {
+				return nil, nil, false, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if name[i+1:] == "" {
+			return schema, root, true, nil
+		}
+		def, scope, ok, err := root.lookup(name[i+1:])
+		if err == nil && !ok {
+			err = fmt.Errorf("%w: %s", ErrTypeRef, name)
+		}
+		return def, scope, ok, err
+	}
+	for ; s != nil; s = s.parent {
+		if def, ok := s.types[name]; ok {
+			return def, s, true, nil
+		}
+	}
+	return nil, nil, false, nil
+}
+
+// resolve follows references to named types. It returns the definition with the markers of all references, the
+// scope the definition is defined in and the name of the first type referred to. Schemas that are not references
+// are returned unchanged.
+func (s *typeScope) resolve(schema interface{}) (interface{}, *typeScope, markers, string, error) {
+	m := make(markers)
+	var first string
+	for i := 0; i < maxTypeRefs; i++ {
+		str, ok := schema.(string)
+		if !ok {
+			return schema, s, m, first, nil
+		}
+		name, refMarkers := splitMarkers(str)
+		for k, e := range refMarkers {
+			if _, ok := m[k]; !ok {
+				m[k] = e
+			}
+		}
+		def, scope, found, err := s.lookup(name)
+		if err != nil {
+			return nil, nil, nil, "", err
+		}
+		if !found {
+			if first == "" {
+				return schema, s, m, "", nil
+			}
+			return name + markerString(m), s, m, first, nil
+		}
+		if first == "" {
+			first = name
+		}
+		schema, s = def, scope
+	}
+	return nil, nil, nil, "", fmt.Errorf("%w: %s: too many references", ErrTypeRef, first)
+}
+
+// expandUnion replaces named types in a union by their definitions.
+func (s *typeScope) expandUnion(str string) (string, error) {
+	name, m := splitMarkers(str)
+	union := splitUnion(name)
+	if len(union) < 2 {
+		return str, nil
+	}
+	for i, e := range union {
+		def, _, _, ref, err := s.resolve(e)
+		if err != nil {
+			return "", err
+		}
+		d, ok := def.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s: union of objects, use %%oneOf", ErrTypeRef, ref)
+		}
+		union[i], _ = splitMarkers(d)
+	}
+	return strings.Join(union, "|") + markerString(m), nil
+}
+
+// markerString returns the markers in schema syntax.
+func markerString(m markers) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := new(strings.Builder)
+	for _, k := range keys {
+		b.WriteString(markerSep + k)
+		if k == markerDefault {
+			b.WriteString("=" + m[k])
+		}
+	}
+	return b.String()
+}
+
+// FileRefs returns the references of schema to types of schema files ("file.json#type"), sorted.
+func FileRefs(schema interface{}) []string {
+	refs := make(map[string]bool)
+	fileRefs(schema, refs)
+	ret := make([]string, 0, len(refs))
+	for k := range refs {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func fileRefs(schema interface{}, refs map[string]bool) {
+	switch s := schema.(type) {
+	case string:
+		name, _ := splitMarkers(s)
+		for _, e := range splitUnion(name) {
+			if !strings.ContainsAny(e, "(|\"") && strings.Contains(e, "#") {
+				refs[e] = true
+			}
+		}
+	case map[string]interface{}:
+		for k, e := range s {
+			if k != markerSep+markerRules && k != markerSep+markerName {
+				fileRefs(e, refs)
+			}
+		}
+	case []interface{}:
+		for _, e := range s {
+			fileRefs(e, refs)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package jsonschema
+
+import (
+	"strconv"
+)
+
+// Unions and oneOf:
+//
+//	"ipv4|ipv4net": The value must match one of the types, the first matching type is used.
+//	{"%oneOf": [{...}, {...}]}: The value must match exactly one of the alternative schemas. Other keys of the map
+//	are added to each alternative. Alternatives can be named with "%name": "static", otherwise they are named by
+//	index. Alternatives are validated in strict mode unless they contain "%strict": false, so that unknown keys
+//	select the branch.
+//
+// Alternatives of oneOf can be named types, they are named like the type unless they contain "%name".
+//
+// The name of the matching type or alternative is recorded in Result.Branches.
+
+// splitUnion splits a type definition at "|" outside of parentheses and quoted strings.
+func splitUnion(s string) []string {
+	var ret []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			ret = append(ret, trimString(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ret, trimString(s[start:]))
+}
+
+func (v *validator) validateOneOf(path []string, schema map[string]interface{}, alternatives, data interface{}, required, secret bool) (interface{}, bool) {
+	rule := markerSep + markerOneOf
+	alts, ok := alternatives.([]interface{})
+	if !ok || len(alts) == 0 {
+		v.fail(path, rule, data, ErrSchemaDefType)
+		return nil, false
+	}
+	if data == nil {
+		if required {
+			v.fail(path, rule, nil, ErrRequired)
+			return nil, false
+		}
+		return nil, true
+	}
+	var match *validator
+	var matchData interface{}
+	var matchName string
+	e := &BranchError{Value: data}
+	for i, alt := range alts {
+		// Alternatives can be named types, which are named like the type.
+		alt, scope, _, ref, err := v.scope.resolve(alt)
+		if err != nil {
+			v.fail(path, rule, data, err)
+			return nil, false
+		}
+		alt = oneOfAlternative(schema, alt)
+		name := strconv.Itoa(i)
+		if ref != "" {
+			name = ref
+		}
+		if m, ok := alt.(map[string]interface{}); ok {
+			if n, ok := m[markerSep+markerName].(string); ok {
+				name = n
+			}
+		}
+		sub := newValidator(true)
+		sub.scope = scope
+		d, ok := sub.validate(path, alt, data, required, secret)
+		// Secrets are redacted also if their branch does not match.
+		v.secrets = append(v.secrets, sub.secrets...)
+		for k := range sub.pointers {
+			v.pointers[k] = true
+		}
+		if ok && len(sub.violations) == 0 {
+			e.Matches++
+			match, matchData, matchName = sub, d, name
+			continue
+		}
+		e.Alternatives = append(e.Alternatives, name)
+		e.Errs = append(e.Errs, sub.violations)
+	}
+	if e.Matches != 1 {
+		if e.Matches > 1 {
+			e.Errs = nil
+		}
+		if secret {
+			// The alternatives have added data to the secrets.
+			v.fail(path, rule, redacted{}, redactError(e))
+		} else {
+			v.fail(path, rule, data, e)
+		}
+		return nil, false
+	}
+	v.warnings = append(v.warnings, match.warnings...)
+	v.rules = append(v.rules, match.rules...)
+	for k, d := range match.defaults {
+		v.defaults[k] = d
+	}
+	for k, b := range match.branches {
+		v.branches[k] = b
+	}
+	v.branch(path, matchName)
+	return matchData, true
+}
+
+// oneOfAlternative adds the keys of schema, except "%oneOf", to alternative if it is a map.
+func oneOfAlternative(schema map[string]interface{}, alternative interface{}) interface{} {
+	alt, ok := alternative.(map[string]interface{})
+	if !ok || len(schema) == 1 {
+		return alternative
+	}
+	ret := make(map[string]interface{}, len(schema)+len(alt))
+	for k, e := range schema {
+		if k != markerSep+markerOneOf {
+			ret[k] = e
+		}
+	}
+	for k, e := range alt {
+		ret[k] = e
+	}
+	return ret
+}
@@ -1,6 +1,7 @@
 package jsonschema
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -10,13 +11,15 @@ func trimString(s string) string {
 	return strings.TrimFunc(s, func(r rune) bool { return unicode.IsSpace(r) })
 }
 
-func reverseStringSlice(s []string) []string {
-	for left, right := 0, len(s)-1; left < right; left, right = left+1, right-1 {
-		s[left], s[right] = s[right], s[left]
-	}
-	return s
-}
-
 func indexString(i int) string {
 	return "[" + strconv.FormatInt(int64(i), 10) + "]"
 }
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,132 +1,585 @@
 package jsonschema
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 )
 
+type markers map[string]string
+
+func (m markers) has(name string) bool {
+	_, ok := m[name]
+	return ok
+}
+
+var knownMarkers = map[string]bool{
+	markerRequired: true,
+	markerSecret:   true,
+	markerDefault:  true,
+	markerNullable: true,
+}
+
+// splitMarkers splits known markers ("%required", "%secret", "%nullable", "%default=value") from the end of a schema definition or key name.
+func splitMarkers(s string) (string, markers) {
+	ret := make(markers)
+	s = trimString(s)
+	for {
+		pos := strings.LastIndex(s, markerSep)
+		if pos < 0 {
+			break
+		}
+		name, value := s[pos+len(markerSep):], ""
+		if p := strings.Index(name, "="); p >= 0 {
+			name, value = name[:p], name[p+1:]
+		}
+		if !knownMarkers[name] {
+			break
+		}
+		ret[name] = value
+		s = trimString(s[:pos])
+	}
+	return s, ret
+}
+
 func nameRequired(name string) (string, bool) {
-	name = trimString(name)
-	if strings.HasSuffix(name, requiredSuffix) {
-		return trimString(name[:len(name)-len(requiredSuffix)]), true
+	name, m := splitMarkers(name)
+	return name, m.has(markerRequired)
+}
+
+// nullable returns true if schema allows explicit null values, by "%nullable" marker or, for maps,
+// "%nullable": true.
+func nullable(schema interface{}) bool {
+	switch s := schema.(type) {
+	case string:
+		_, m := splitMarkers(s)
+		return m.has(markerNullable)
+	case map[string]interface{}:
+		b, _ := s[markerSep+markerNullable].(bool)
+		return b
 	}
-	return name, false
+	return false
+}
+
+// isDirective returns true for schema keys that are not field names but control validation, like "%strict".
+func isDirective(key string) bool {
+	return strings.HasPrefix(key, markerSep)
+}
+
+// validator contains the state of a validation run.
+type validator struct {
+	secrets    []string
+	pointers   map[string]bool        // JSON pointers of secret values.
+	defaults   map[string]interface{} // Default values as written in the schema, by JSON pointer.
+	violations Violations
+	warnings   Violations
+	branches   map[string]string
+	rules      []*rule
+	scope      *typeScope      // Named types visible at the current schema.
+	expanding  map[string]bool // Named types validated for missing data, to stop at recursive types.
+	strict     bool
 }
 
-func validationData(s interface{}) (valFunc ValidatorFunc, required bool, err error) {
-	var ok bool
-	var q, funcName string
-	if q, ok = s.(string); !ok {
-		return nil, true, ErrSchemaDefType
+func newValidator(strict bool) *validator {
+	return &validator{
+		branches:  make(map[string]string),
+		pointers:  make(map[string]bool),
+		defaults:  make(map[string]interface{}),
+		expanding: make(map[string]bool),
+		scope:     newScope(),
+		strict:    strict,
 	}
-	funcName, required = nameRequired(q)
+}
+
+// branch records the alternative of a union or oneOf that matched at path.
+func (v *validator) branch(path []string, name string) {
+	if name != "" {
+		v.branches[PointerString(path)] = name
+	}
+}
+
+func (v *validator) addSecret(path []string, data interface{}) {
+	if data != nil {
+		v.pointers[PointerString(path)] = true
+	}
+	v.addSecretValues(data)
+}
+
+func (v *validator) addSecretValues(data interface{}) {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		for _, e := range d {
+			v.addSecretValues(e)
+		}
+	case []interface{}:
+		for _, e := range d {
+			v.addSecretValues(e)
+		}
+	case nil, bool:
+	case string:
+		if d != "" {
+			v.secrets = append(v.secrets, d)
+		}
+	default:
+		v.secrets = append(v.secrets, fmt.Sprint(d))
+	}
+}
+
+// fail records a violation at path.
+func (v *validator) fail(path []string, rule string, value interface{}, err error) {
+	v.violations = append(v.violations, &Violation{
+		Path:  append([]string{}, path...),
+		Rule:  rule,
+		Value: value,
+		Err:   err,
+	})
+}
+
+// failType records that data is not an object or array. Secret values are redacted.
+func (v *validator) failType(path []string, rule string, data interface{}, secret bool) {
+	if secret {
+		v.addSecret(path, data)
+		v.fail(path, rule, redacted{}, &TypeError{Type: rule, Value: redacted{}, Err: ErrSchemaType})
+		return
+	}
+	v.fail(path, rule, data, &TypeError{Type: rule, Value: data, Err: ErrSchemaType})
+}
+
+// warn records a warning at path.
+func (v *validator) warn(path []string, rule string, value interface{}, err error) {
+	v.warnings = append(v.warnings, &Violation{
+		Path:  append([]string{}, path...),
+		Rule:  rule,
+		Value: value,
+		Err:   err,
+	})
+}
+
+// checkRules checks the rules of all maps on the validated data. Written is the data as written, with the defaults as
+// written in the schema.
+func (v *validator) checkRules(root, written interface{}) {
+	for _, r := range v.rules {
+		if err := r.check(root, written, v.pointers); err != nil {
+			v.fail(r.path, r.expr, nil, err)
+		}
+	}
+}
+
+func appendPath(path []string, e string) []string {
+	return append(path[:len(path):len(path)], e)
+}
+
+// typeDef is a parsed schema definition of a value.
+type typeDef struct {
+	valFunc      ValidatorFunc
+	name         string
+	required     bool
+	secret       bool
+	def          string // Default value, given as "default=" parameter or "%default=" marker.
+	hasDefault   bool
+	alternatives []*typeDef // Types of a union, tried in order.
+}
+
+func validationData(s interface{}) (*typeDef, error) {
+	q, ok := s.(string)
+	if !ok {
+		return nil, ErrSchemaDefType
+	}
+	funcName, m := splitMarkers(q)
 	if funcName == "" {
 		funcName = defaultType
 	}
-	funcName, parameters := extractParameters(funcName)
-	if valFunc, ok := validatorFuncMap[funcName]; ok {
-		if parameters != nil && len(parameters) > 0 {
-			return func(i ...any) (interface{}, error) {
-				if len(i) > 0 {
-					return valFunc(i[0], parameters)
-				}
-				return nil, ErrViolationType
-			}, required, nil
+	var t *typeDef
+	if union := splitUnion(funcName); len(union) > 1 {
+		t = &typeDef{name: funcName}
+		for _, e := range union {
+			alt, err := parseType(e)
+			if err != nil {
+				return nil, err
+			}
+			if alt.hasDefault && !t.hasDefault {
+				t.def, t.hasDefault = alt.def, true
+			}
+			t.alternatives = append(t.alternatives, alt)
 		}
-		return valFunc, required, nil
+	} else {
+		var err error
+		if t, err = parseType(funcName); err != nil {
+			return nil, err
+		}
+	}
+	t.required, t.secret = m.has(markerRequired), m.has(markerSecret)
+	if def, ok := m[markerDefault]; ok {
+		t.def, t.hasDefault = def, true
 	}
-	return nil, true, ErrSchemaDefValidator
+	return t, nil
 }
 
-func compareType(schema, data interface{}, required bool) (interface{}, error) {
-	valFunc, required2, err := validationData(schema)
+// parseType parses a single type with its parameters.
+func parseType(s string) (*typeDef, error) {
+	funcName, parameters, err := extractParameters(s)
 	if err != nil {
 		return nil, err
 	}
-	required = required2 || required
+	t := &typeDef{name: funcName}
+	if def, ok := parameters[paramDefault]; ok {
+		t.def = paramString(def)
+		t.hasDefault = true
+		delete(parameters, paramDefault)
+	}
+	valFunc, ok := validatorFuncMap[funcName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaDefValidator, funcName)
+	}
+	t.valFunc = valFunc
+	if len(parameters) > 0 {
+		t.valFunc = func(i ...any) (interface{}, error) {
+			if len(i) > 0 {
+				return valFunc(i[0], parameters)
+			}
+			return nil, ErrViolationType
+		}
+	}
+	return t, nil
+}
+
+// check validates data. For unions it returns the name of the first matching type.
+func (t *typeDef) check(data interface{}) (interface{}, string, error) {
+	if len(t.alternatives) == 0 {
+		d, err := t.valFunc(data)
+		if err != nil {
+			return nil, "", typeError(t.name, data, err)
+		}
+		return d, "", nil
+	}
+	e := &BranchError{Value: data}
+	for _, alt := range t.alternatives {
+		d, _, err := alt.check(data)
+		if err == nil {
+			return d, alt.name, nil
+		}
+		e.Alternatives = append(e.Alternatives, alt.name)
+		e.Errs = append(e.Errs, err)
+	}
+	return nil, "", e
+}
+
+// defaultValue returns the default converted to the type. Numbers and booleans are tried before strings, the
+// error of the first attempt is returned.
+// defaultValue returns the validated default value, the value as written in the schema and the matched branch.
+func (t *typeDef) defaultValue() (interface{}, interface{}, string, error) {
+	candidates := make([]interface{}, 0, 2)
+	if f, err := strconv.ParseFloat(t.def, 64); err == nil {
+		candidates = append(candidates, f)
+	} else if b, err := strconv.ParseBool(t.def); err == nil {
+		candidates = append(candidates, b)
+	}
+	candidates = append(candidates, t.def)
+	var firstErr error
+	for _, c := range candidates {
+		d, branch, err := t.check(c)
+		if err == nil {
+			return d, c, branch, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, nil, "", fmt.Errorf("default: %w", firstErr)
+}
+
+func (v *validator) compareType(path []string, schema, data interface{}, required, secret bool) (interface{}, bool) {
+	rule := fmt.Sprint(schema)
+	if s, ok := schema.(string); ok {
+		var err error
+		if schema, err = v.scope.expandUnion(s); err != nil {
+			v.fail(path, rule, data, err)
+			return nil, false
+		}
+	}
+	t, err := validationData(schema)
+	if err != nil {
+		v.fail(path, rule, data, err)
+		return nil, false
+	}
+	required = t.required || required
+	secret = t.secret || secret
+	if data == nil && t.hasDefault {
+		d, raw, branch, err := t.defaultValue()
+		if err != nil {
+			v.fail(path, rule, nil, err)
+			return nil, false
+		}
+		v.defaults[PointerString(path)] = raw
+		if secret {
+			v.addSecret(path, d)
+		}
+		v.branch(path, branch)
+		return d, true
+	}
 	if required && data == nil {
-		return nil, ErrRequired
+		v.fail(path, rule, nil, ErrRequired)
+		return nil, false
 	}
 	if data == nil {
-		return nil, nil
+		return nil, true
 	}
-	return valFunc(data)
+	if secret {
+		v.addSecret(path, data)
+	}
+	d, branch, err := t.check(data)
+	if err != nil {
+		if secret {
+			v.fail(path, rule, redacted{}, redactError(err))
+		} else {
+			v.fail(path, rule, data, err)
+		}
+		return nil, false
+	}
+	if secret {
+		v.addSecret(path, d)
+	}
+	v.branch(path, branch)
+	return d, true
 }
 
-func validateMap(schema map[string]interface{}, data interface{}, required bool) ([]string, interface{}, error) {
+func (v *validator) validateMap(path []string, schema map[string]interface{}, data interface{}, required, secret bool) (interface{}, bool) {
+	scope, err := v.scope.with(schema)
+	if err != nil {
+		v.fail(path, markerSep+markerTypes, schema[markerSep+markerTypes], err)
+		return nil, false
+	}
+	defer func(old *typeScope) { v.scope = old }(v.scope)
+	v.scope = scope
+	if alternatives, ok := schema[markerSep+markerOneOf]; ok {
+		return v.validateOneOf(path, schema, alternatives, data, required, secret)
+	}
 	var expand bool
 	var dataV interface{}
 	var dataT map[string]interface{}
 	ret := make(map[string]interface{})
 	if data != nil {
 		if dataT, expand = data.(map[string]interface{}); !expand {
-			return nil, nil, ErrSchemaType
+			v.failType(path, ruleObject, data, secret)
+			return nil, false
 		}
 	}
 	if data == nil && required {
-		return nil, nil, ErrRequired
+		v.fail(path, ruleObject, nil, ErrRequired)
+		return nil, false
+	}
+	if strict, ok := schema[markerSep+markerStrict]; ok {
+		if s, ok := strict.(bool); !ok {
+			v.fail(path, markerSep+markerStrict, strict, ErrSchemaDefType)
+		} else {
+			defer func(old bool) { v.strict = old }(v.strict)
+			v.strict = s
+		}
 	}
-	for k, v := range schema {
+	valid := true
+	known := make(map[string]bool, len(schema))
+	patterns, err := mapPatterns(schema)
+	if err != nil {
+		v.fail(path, ruleObject, data, err)
+		return nil, false
+	}
+	var keyType *typeDef
+	if keys, ok := schema[markerSep+markerKeys]; ok {
+		if keys, _, _, _, err = v.scope.resolve(keys); err == nil {
+			keyType, err = validationData(keys)
+		}
+		if err != nil {
+			v.fail(path, markerSep+markerKeys, keys, err)
+			return nil, false
+		}
+	}
+	for _, key := range sortedKeys(schema) {
+		if isDirective(key) || isPattern(key) {
+			continue
+		}
+		k, m := keyName(key)
+		known[k] = true
 		dataV = nil
-		k, required = nameRequired(k)
+		required = m.has(markerRequired)
 		if expand || required {
 			var ok bool
 			dataV, ok = dataT[k]
+			if ok && dataV == nil && (m.has(markerNullable) || nullable(schema[key])) {
+				ret[k] = nil
+				continue
+			}
 			if !ok && required {
-				return []string{k}, nil, ErrRequired
+				v.fail(appendPath(path, k), fmt.Sprint(schema[key]), nil, ErrRequired)
+				valid = false
+				continue
 			}
 		}
-		if p, d, err := validate(v, dataV, required); err != nil {
-			return append(p, k), nil, err
-		} else if expand {
+		if d, ok := v.validate(appendPath(path, k), schema[key], dataV, required, secret || m.has(markerSecret)); !ok {
+			valid = false
+		} else if expand || d != nil {
+			// Missing maps contain the defaults of their fields.
 			ret[k] = d
 		}
 	}
-	return nil, ret, nil
+	matched := make([]bool, len(patterns))
+	for _, k := range sortedKeys(dataT) {
+		if known[k] {
+			continue
+		}
+		if keyType != nil {
+			if _, _, err := keyType.check(k); err != nil {
+				v.fail(appendPath(path, k), markerSep+markerKeys, k, fmt.Errorf("key: %w", err))
+				valid = false
+				continue
+			}
+		}
+		if i := matchPattern(patterns, k); i >= 0 {
+			matched[i] = true
+			p := patterns[i]
+			if d, ok := v.validate(appendPath(path, k), schema[p.key], dataT[k], false, secret || p.markers.has(markerSecret)); !ok {
+				valid = false
+			} else {
+				ret[k] = d
+			}
+			continue
+		}
+		if v.strict {
+			v.fail(appendPath(path, k), ruleObject, dataT[k], ErrUnknownKey)
+			valid = false
+			continue
+		}
+		v.warn(appendPath(path, k), ruleObject, dataT[k], ErrUnknownKey)
+		if secret {
+			v.addSecret(appendPath(path, k), dataT[k])
+		}
+		ret[k] = dataT[k]
+	}
+	for i, p := range patterns {
+		if !matched[i] && p.markers.has(markerRequired) {
+			v.fail(appendPath(path, p.name), p.key, nil, ErrRequired)
+			valid = false
+		}
+	}
+	if rules, ok := schema[markerSep+markerRules]; ok && data != nil {
+		parsed, err := parseRules(rules)
+		if err != nil {
+			v.fail(path, markerSep+markerRules, rules, err)
+			return nil, false
+		}
+		// Rules can refer to any value of the data, they are checked after validation has finished.
+		for _, r := range parsed {
+			r.path, r.local = append([]string{}, path...), ret
+		}
+		v.rules = append(v.rules, parsed...)
+	}
+	return ret, valid
 }
 
-func validateArray(schema []interface{}, data interface{}, required bool) ([]string, interface{}, error) {
-	if len(schema) != 1 {
-		return nil, nil, ErrArraySchema
+func (v *validator) validateArray(path []string, schema []interface{}, data interface{}, required, secret bool) (interface{}, bool) {
+	a, err := parseArray(schema)
+	if err != nil {
+		v.fail(path, ruleArray, data, err)
+		return nil, false
 	}
 	if data == nil {
 		if required {
-			return nil, nil, ErrRequired
+			v.fail(path, ruleArray, nil, ErrRequired)
+			return nil, false
 		}
-		return nil, nil, nil
+		return nil, true
 	}
 	if dataV, ok := data.([]interface{}); ok {
-		if len(dataV) == 0 {
-			if required {
-				return nil, nil, ErrRequired
+		if len(dataV) == 0 && required {
+			v.fail(path, ruleArray, data, ErrRequired)
+			return nil, false
+		}
+		valid := true
+		if err := a.checkLength(len(dataV)); err != nil {
+			v.fail(path, ruleArray, len(dataV), err)
+			valid = false
+		}
+		n := len(dataV)
+		if a.tuple() && n < len(a.items) {
+			// Missing positions of tuples are validated as null, applying defaults and required markers.
+			n = len(a.items)
+		}
+		ret := make([]interface{}, 0, n)
+		for k := 0; k < n; k++ {
+			var e interface{}
+			if k < len(dataV) {
+				e = dataV[k]
+			}
+			item, ok := a.item(k)
+			if !ok {
+				break
+			}
+			if e == nil && nullable(item) {
+				ret = append(ret, nil)
+				continue
+			}
+			d, ok := v.validate(appendPath(path, indexString(k)), item, e, required && !a.tuple(), secret)
+			if !ok {
+				valid = false
 			}
-			return nil, make([]interface{}, 0), nil
+			ret = append(ret, d)
 		}
-		ret := make([]interface{}, len(dataV))
-		for k, v := range dataV {
-			if p, d, err := validate(schema[0], v, required); err != nil {
-				return append(p, indexString(k)), nil, err
-			} else {
-				ret[k] = d
+		if a.tuple() {
+			// Trailing missing positions without default are not added.
+			for len(ret) > len(dataV) && ret[len(ret)-1] == nil {
+				ret = ret[:len(ret)-1]
+			}
+		}
+		for k, err := range a.duplicates(dataV) {
+			e := dataV[k]
+			if secret {
+				e, err = redacted{}, redactError(err)
 			}
+			v.fail(appendPath(path, indexString(k)), ruleArray, e, err)
+			valid = false
 		}
-		return nil, ret, nil
+		return ret, valid
 	}
-	return nil, nil, ErrSchemaType
+	v.failType(path, ruleArray, data, secret)
+	return nil, false
 }
 
-func validate(schema, data interface{}, required bool) ([]string, interface{}, error) {
+func (v *validator) validate(path []string, schema, data interface{}, required, secret bool) (interface{}, bool) {
+	resolved, scope, m, ref, err := v.scope.resolve(schema)
+	if err != nil {
+		v.fail(path, fmt.Sprint(schema), data, err)
+		return nil, false
+	}
+	schema = resolved
+	if ref != "" {
+		if _, ok := schema.(string); !ok {
+			required = required || m.has(markerRequired)
+			secret = secret || m.has(markerSecret)
+			if data == nil {
+				// Missing maps contain the defaults of their fields, which must end at recursive types.
+				key := fmt.Sprintf("%p", schema)
+				if v.expanding[key] {
+					if required {
+						v.fail(path, ref, nil, ErrRequired)
+						return nil, false
+					}
+					return nil, true
+				}
+				v.expanding[key] = true
+				defer delete(v.expanding, key)
+			}
+		}
+		defer func(old *typeScope) { v.scope = old }(v.scope)
+		v.scope = scope
+	}
 	switch m := schema.(type) {
 	case map[string]interface{}:
-		return validateMap(m, data, required)
+		return v.validateMap(path, m, data, required, secret)
 	case []interface{}:
-		return validateArray(m, data, required)
+		return v.validateArray(path, m, data, required, secret)
 	case interface{}:
-		d, err := compareType(m, data, required)
-		if err != nil {
-			return nil, nil, err
-		}
-		return nil, d, nil
+		return v.compareType(path, m, data, required, secret)
 	default:
-		return nil, nil, ErrUnknownType
+		v.fail(path, "", data, ErrUnknownType)
+		return nil, false
 	}
 }
@@ -1,13 +1,19 @@
 package jsonschema
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/dnsquery"
 	"github.com/akamensky/base58"
 	"net"
 	"os"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -51,14 +57,14 @@ func isFloat(s ...interface{}) (interface{}, error) {
 		return nil, err
 	} else if ok {
 		if q < min {
-			return nil, ErrParamConstraint
+			return nil, constraintError("min", min, q)
 		}
 	}
 	if max, ok, err := params.AsFloat("max"); err != nil {
 		return nil, err
 	} else if ok {
 		if q > max {
-			return nil, ErrParamConstraint
+			return nil, constraintError("max", max, q)
 		}
 	}
 	return q, nil
@@ -97,14 +103,14 @@ func isInt(s ...interface{}) (interface{}, error) {
 		return nil, err
 	} else if ok {
 		if int64(q) < min {
-			return nil, ErrParamConstraint
+			return nil, constraintError("min", min, q)
 		}
 	}
 	if max, ok, err := params.AsInt("max"); err != nil {
 		return nil, err
 	} else if ok {
 		if int64(q) > max {
-			return nil, ErrParamConstraint
+			return nil, constraintError("max", max, q)
 		}
 	}
 	return q, nil
@@ -152,9 +158,12 @@ func isDuration(s ...interface{}) (interface{}, error) {
 		params = s[1].(ParamMap)
 	}
 	var q time.Duration
-	if d, ok := s[0].(string); !ok {
-		return nil, ErrViolationType
-	} else if q, err = time.ParseDuration(d); err != nil {
+	switch d := s[0].(type) {
+	case string:
+		if q, err = time.ParseDuration(d); err != nil {
+			return nil, ErrViolationType
+		}
+	default:
 		return nil, ErrViolationType
 	}
 	if minS, ok, err := params.AsString("min"); err != nil {
@@ -163,7 +172,7 @@ func isDuration(s ...interface{}) (interface{}, error) {
 		if min, err := time.ParseDuration(minS); err != nil {
 			return nil, err
 		} else if q < min {
-			return nil, ErrParamConstraint
+			return nil, constraintError("min", minS, q)
 		}
 	}
 	if maxS, ok, err := params.AsString("max"); err != nil {
@@ -172,18 +181,169 @@ func isDuration(s ...interface{}) (interface{}, error) {
 		if max, err := time.ParseDuration(maxS); err != nil {
 			return nil, err
 		} else if q > max {
-			return nil, ErrParamConstraint
+			return nil, constraintError("max", maxS, q)
 		}
 	}
 	return q, nil
 }
 
+func isBool(s ...interface{}) (interface{}, error) {
+	if len(s) < 1 {
+		return nil, ErrViolationType
+	}
+	if b, ok := s[0].(bool); ok {
+		return b, nil
+	}
+	return nil, ErrViolationType
+}
+
+// isNull accepts no value. Missing and null values are not validated, so every value violates it.
+func isNull(s ...interface{}) (interface{}, error) {
+	if len(s) < 1 || s[0] != nil {
+		return nil, ErrViolationType
+	}
+	return nil, nil
+}
+
+// isEnum accepts strings and numbers listed as flag "a|b|c" or as quoted arguments. Strings are compared
+// case-sensitive unless the flag "ignorecase" is given.
+func isEnum(s ...interface{}) (interface{}, error) {
+	var params ParamMap
+	if len(s) < 1 {
+		return nil, ErrViolationType
+	}
+	if len(s) > 1 {
+		params = s[1].(ParamMap)
+	}
+	ignoreCase := params.HasFlag(paramIgnoreCase)
+	var values []string
+	for _, f := range params.Flags() {
+		if !strings.EqualFold(f, paramIgnoreCase) {
+			values = append(values, strings.Split(f, "|")...)
+		}
+	}
+	for _, a := range params.Args() {
+		values = append(values, paramString(a))
+	}
+	if len(values) == 0 {
+		return nil, ErrParamType
+	}
+	for _, e := range values {
+		switch q := s[0].(type) {
+		case string:
+			if q == e || (ignoreCase && strings.EqualFold(q, e)) {
+				return q, nil
+			}
+		case float64:
+			if f, err := strconv.ParseFloat(e, 64); err == nil && f == q {
+				return q, nil
+			}
+		case int:
+			if f, err := strconv.ParseFloat(e, 64); err == nil && f == float64(q) {
+				return q, nil
+			}
+		default:
+			return nil, ErrViolationType
+		}
+	}
+	return nil, constraintError(paramEnum, strings.Join(values, "|"), s[0])
+}
+
+// stringParam returns the parameter key, or the first positional argument.
+func stringParam(params ParamMap, key string) (string, error) {
+	if v, ok, err := params.AsString(key); err != nil || ok {
+		return v, err
+	}
+	if args := params.Args(); len(args) > 0 {
+		return paramString(args[0]), nil
+	}
+	return "", ErrParamType
+}
+
+var (
+	regexCache   = make(map[string]*regexp.Regexp)
+	regexCacheMu sync.Mutex
+)
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCacheMu.Lock()
+	defer regexCacheMu.Unlock()
+	if re, ok := regexCache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache[pattern] = re
+	return re, nil
+}
+
+// isStringMatch returns a validator for strings that satisfy match for the parameter key. String length
+// constraints apply as well.
+func isStringMatch(key string, match func(s, param string) (bool, error)) ValidatorFunc {
+	return func(s ...interface{}) (interface{}, error) {
+		var params ParamMap
+		if len(s) < 1 {
+			return nil, ErrViolationType
+		}
+		if len(s) > 1 {
+			params = s[1].(ParamMap)
+		}
+		q, ok := s[0].(string)
+		if !ok {
+			return nil, ErrViolationType
+		}
+		param, err := stringParam(params, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := match(q, param); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, constraintError(key, param, q)
+		}
+		if err := checkStrConstraints(q, params); err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+}
+
+func isRegex(s ...interface{}) (interface{}, error) {
+	return isStringMatch(paramPattern, func(s, pattern string) (bool, error) {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", err, ErrParamType)
+		}
+		return re.MatchString(s), nil
+	})(s...)
+}
+
+func isPrefix(s ...interface{}) (interface{}, error) {
+	return isStringMatch(paramPrefix, func(s, prefix string) (bool, error) {
+		return strings.HasPrefix(s, prefix), nil
+	})(s...)
+}
+
+func isSuffix(s ...interface{}) (interface{}, error) {
+	return isStringMatch(paramSuffix, func(s, suffix string) (bool, error) {
+		return strings.HasSuffix(s, suffix), nil
+	})(s...)
+}
+
+func isContains(s ...interface{}) (interface{}, error) {
+	return isStringMatch(paramContains, func(s, sub string) (bool, error) {
+		return strings.Contains(s, sub), nil
+	})(s...)
+}
+
 func checkStrLen(s string, params ParamMap) error {
 	if l, ok, err := params.AsInt("len"); err != nil {
 		return err
 	} else if ok {
 		if len(s) != int(l) {
-			return ErrParamConstraint
+			return lengthError("len", l, len(s))
 		}
 	}
 	return nil
@@ -194,7 +354,7 @@ func checkStrMinLen(s string, params ParamMap) error {
 		return err
 	} else if ok {
 		if len(s) < int(l) {
-			return ErrParamConstraint
+			return lengthError("min", l, len(s))
 		}
 	}
 	return nil
@@ -205,7 +365,7 @@ func checkStrMaxLen(s string, params ParamMap) error {
 		return err
 	} else if ok {
 		if len(s) > int(l) {
-			return ErrParamConstraint
+			return lengthError("max", l, len(s))
 		}
 	}
 	return nil
@@ -451,3 +611,80 @@ func lookupIPv4(s ...interface{}) (interface{}, error) {
 func lookupIPv6(s ...interface{}) (interface{}, error) {
 	return lookupAddr(6, s...)
 }
+
+// dnsResolver are the lookups of net.Resolver used by the validators.
+type dnsResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
+
+// resolver and queryCAA are replaced by tests.
+var (
+	resolver dnsResolver = net.DefaultResolver
+	queryCAA             = dnsquery.LookupCAA
+)
+
+func lookupRecord(lookup func(string) (int, error), s ...interface{}) (interface{}, error) {
+	if len(s) < 1 {
+		return nil, ErrViolationType
+	}
+	if str, ok := s[0].(string); ok {
+		if n, err := lookup(str); err != nil || n == 0 {
+			return nil, ErrViolationType
+		}
+		return str, nil
+	}
+	return nil, ErrViolationType
+}
+
+func lookupSRV(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(name string) (int, error) {
+		_, addrs, err := resolver.LookupSRV(context.Background(), "", "", name)
+		return len(addrs), err
+	}, s...)
+}
+
+func lookupMX(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(name string) (int, error) {
+		addrs, err := resolver.LookupMX(context.Background(), name)
+		return len(addrs), err
+	}, s...)
+}
+
+func lookupCNAME(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(name string) (int, error) {
+		cname, err := resolver.LookupCNAME(context.Background(), name)
+		if err != nil {
+			return 0, err
+		}
+		// LookupCNAME returns the name itself if there is no CNAME record.
+		if strings.TrimSuffix(cname, ".") == strings.TrimSuffix(name, ".") {
+			return 0, nil
+		}
+		return 1, nil
+	}, s...)
+}
+
+func lookupPTR(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(addr string) (int, error) {
+		names, err := resolver.LookupAddr(context.Background(), addr)
+		return len(names), err
+	}, s...)
+}
+
+func lookupNS(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(name string) (int, error) {
+		ns, err := resolver.LookupNS(context.Background(), name)
+		return len(ns), err
+	}, s...)
+}
+
+func lookupCAA(s ...interface{}) (interface{}, error) {
+	return lookupRecord(func(name string) (int, error) {
+		caa, err := queryCAA(name)
+		return len(caa), err
+	}, s...)
+}
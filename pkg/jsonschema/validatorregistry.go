@@ -13,6 +13,13 @@ func init() {
 	RegisterValidatorFunc("dir", isDir)
 	RegisterValidatorFunc("file", isFile)
 	RegisterValidatorFunc("duration", isDuration)
+	RegisterValidatorFunc("bool", isBool)
+	RegisterValidatorFunc("null", isNull)
+	RegisterValidatorFunc("enum", isEnum)
+	RegisterValidatorFunc("regex", isRegex)
+	RegisterValidatorFunc("prefix", isPrefix)
+	RegisterValidatorFunc("suffix", isSuffix)
+	RegisterValidatorFunc("contains", isContains)
 	RegisterValidatorFunc("hex", isHex)
 	RegisterValidatorFunc("base64", isBase64)
 	RegisterValidatorFunc("base58", isBase58)
@@ -26,4 +33,10 @@ func init() {
 	RegisterValidatorFunc("nic6", isNIC6)
 	RegisterValidatorFunc("lookup4", lookupIPv4)
 	RegisterValidatorFunc("lookup6", lookupIPv6)
+	RegisterValidatorFunc("lookupsrv", lookupSRV)
+	RegisterValidatorFunc("lookupmx", lookupMX)
+	RegisterValidatorFunc("lookupcname", lookupCNAME)
+	RegisterValidatorFunc("lookupptr", lookupPTR)
+	RegisterValidatorFunc("lookupns", lookupNS)
+	RegisterValidatorFunc("lookupcaa", lookupCAA)
 }
@@ -0,0 +1,146 @@
+package jsonschema
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Violation describes a value that does not conform to the schema.
+type Violation struct {
+	Path  []string    // Path of the value. Array elements are "[index]".
+	Rule  string      // Schema definition that was violated.
+	Value interface{} // Offending value, nil if missing.
+	Err   error       // Cause of the violation.
+}
+
+func (v *Violation) Error() string {
+	return PathString(v.Path) + ": " + v.Err.Error()
+}
+
+func (v *Violation) Unwrap() error {
+	return v.Err
+}
+
+// Violations is a list of violations sorted by path.
+type Violations []*Violation
+
+func (v Violations) Error() string {
+	s := make([]string, len(v))
+	for i, e := range v {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (v Violations) sort() {
+	sort.SliceStable(v, func(i, j int) bool {
+		return comparePath(v[i].Path, v[j].Path) < 0
+	})
+}
+
+// comparePath compares paths element by element. Array indices are compared numerically.
+func comparePath(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		ai, aIsIndex := parseIndex(a[i])
+		bi, bIsIndex := parseIndex(b[i])
+		if aIsIndex && bIsIndex {
+			if ai < bi {
+				return -1
+			}
+			return 1
+		}
+		if a[i] < b[i] {
+			return -1
+		}
+		return 1
+	}
+	return len(a) - len(b)
+}
+
+func parseIndex(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(s[1 : len(s)-1])
+	return i, err == nil
+}
+
+// PathString formats path as "network[0].ipv4".
+func PathString(path []string) string {
+	if len(path) == 0 {
+		return "."
+	}
+	b := new(strings.Builder)
+	for i, e := range path {
+		if _, isIndex := parseIndex(e); !isIndex && i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(e)
+	}
+	return b.String()
+}
+
+// Pointer returns the path of the violation as JSON pointer.
+func (v *Violation) Pointer() string {
+	return PointerString(v.Path)
+}
+
+// PointerString formats path as JSON pointer (RFC 6901), e.g. "/network/0/ipv4".
+func PointerString(path []string) string {
+	b := new(strings.Builder)
+	for _, e := range path {
+		b.WriteString("/")
+		if i, isIndex := parseIndex(e); isIndex {
+			b.WriteString(strconv.Itoa(i))
+			continue
+		}
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(e, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// PointerPath converts a JSON pointer to a path. Elements that index an array of schema are written as "[index]".
+// Options are those of the validation.
+func PointerPath(schema interface{}, pointer string, options ...Option) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrPointer
+	}
+	elements := strings.Split(pointer[1:], "/")
+	path := make([]string, 0, len(elements))
+	scope := optionScope(options)
+	for _, e := range elements {
+		e = strings.ReplaceAll(strings.ReplaceAll(e, "~1", "/"), "~0", "~")
+		var err error
+		if schema, scope, _, _, err = scope.resolve(schema); err != nil {
+			schema = nil
+		}
+		switch s := schema.(type) {
+		case []interface{}:
+			i, err := strconv.Atoi(e)
+			if err != nil || i < 0 {
+				return nil, ErrPointer
+			}
+			a, err := parseArray(s)
+			if err != nil {
+				return nil, ErrPointer
+			}
+			path = append(path, indexString(i))
+			schema, _ = a.item(i)
+		case map[string]interface{}:
+			path = append(path, e)
+			scope, _ = scope.with(s)
+			schema, _ = lookupKey(s, e)
+		default:
+			path = append(path, e)
+			schema = nil
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,76 @@
+package redact
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replacement is written instead of secret values.
+const Replacement = "[REDACTED]"
+
+var (
+	mutex   sync.RWMutex
+	secrets = make(map[string]bool)
+	sorted  []string
+)
+
+// Add registers secret values to be redacted. Empty values are ignored.
+func Add(values ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, s := range values {
+		if s == "" || secrets[s] {
+			continue
+		}
+		secrets[s] = true
+		sorted = append(sorted, s)
+	}
+	// Longest first, so that secrets containing other secrets are replaced completely.
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+}
+
+// String returns s with all registered secret values replaced. Only whole words are replaced: a secret that starts or
+// ends with a letter or digit is not replaced where it is part of a longer word or number, so that short secrets do
+// not garble unrelated text. Secrets that were transformed, e.g. encoded, are not found.
+func String(s string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, secret := range sorted {
+		s = replaceWord(s, secret)
+	}
+	return s
+}
+
+// replaceWord replaces the occurrences of secret in s that are not part of a longer word.
+func replaceWord(s, secret string) string {
+	b := new(strings.Builder)
+	for {
+		i := strings.Index(s, secret)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(secret)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		first, _ := utf8.DecodeRuneInString(secret)
+		last, _ := utf8.DecodeLastRuneInString(secret)
+		if (isWord(first) && i > 0 && isWord(before)) || (isWord(last) && end < len(s) && isWord(after)) {
+			// Part of a longer word, keep the first rune and continue after it.
+			_, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteString(s[:i+size])
+			s = s[i+size:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(Replacement)
+		s = s[end:]
+	}
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,19 @@
+package redact
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	Add("secret", "topsecret", "12", "p@ss!", "")
+	for s, expect := range map[string]string{
+		"a topsecret and a secret": "a " + Replacement + " and a " + Replacement,
+		"secrets and xsecret":      "secrets and xsecret",
+		"port 8123, pin 12.":       "port 8123, pin " + Replacement + ".",
+		"password=p@ss!x":          "password=" + Replacement + "x",
+	} {
+		if r := String(s); r != expect {
+			t.Errorf("Wrong redaction of %q: %q", s, r)
+		}
+	}
+}
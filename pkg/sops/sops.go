@@ -0,0 +1,366 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decryption of SOPS (https://github.com/getsops/sops) encrypted JSON/YAML files. The data key is decrypted with
+// local age identities or PGP secret keys. Values are decrypted in memory. The MAC of the file is verified over the
+// decrypted values in document order.
+
+const (
+	MetadataKey = "sops"
+	dataKeySize = 32
+	ivSize      = 32
+)
+
+var (
+	ErrNoKey      = errors.New("no key found to decrypt data key")
+	ErrMetadata   = errors.New("invalid sops metadata")
+	ErrValue      = errors.New("invalid encrypted value")
+	ErrValueType  = errors.New("unknown encrypted value type")
+	ErrPassphrase = errors.New("PGP key is passphrase protected")
+	ErrMAC        = errors.New("MAC mismatch")
+	ErrNoMAC      = errors.New("missing MAC")
+)
+
+var encRegex = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// Keys contains the keys available to decrypt data keys.
+type Keys struct {
+	Age []age.Identity
+	PGP openpgp.EntityList
+}
+
+// LoadAgeKeys adds the age identities from filename.
+func (keys *Keys) LoadAgeKeys(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	keys.Age = append(keys.Age, ids...)
+	return nil
+}
+
+// LoadPGPKeys adds the PGP secret keys from filename. Armored and binary keyrings are supported.
+func (keys *Keys) LoadPGPKeys(filename string) error {
+	d, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var entities openpgp.EntityList
+	if bytes.Contains(d, []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(d))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(d))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	keys.PGP = append(keys.PGP, entities...)
+	return nil
+}
+
+// IsEncrypted returns true if d contains sops metadata.
+func IsEncrypted(d interface{}) bool {
+	m, ok := d.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	metadata, ok := m[MetadataKey].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, mac := metadata["mac"].(string)
+	return mac && metadata["version"] != nil
+}
+
+// Decrypt decrypts all values of d, verifies the MAC and removes the sops metadata. Order returns the line and column
+// of the value at a JSON pointer (RFC 6901), zero if unknown. The MAC depends on the document order of the values,
+// which decoded maps do not keep. If order is nil, map keys are sorted. Returns false if d is not encrypted.
+func (keys *Keys) Decrypt(d interface{}, order func(pointer string) (int, int)) (interface{}, bool, error) {
+	if !IsEncrypted(d) {
+		return d, false, nil
+	}
+	m := d.(map[string]interface{})
+	metadata := m[MetadataKey].(map[string]interface{})
+	dataKey, err := keys.dataKey(metadata)
+	if err != nil {
+		return nil, true, err
+	}
+	ret := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k == MetadataKey {
+			continue
+		}
+		if ret[k], err = decryptTree(dataKey, v, []string{k}); err != nil {
+			return nil, true, err
+		}
+	}
+	if err := verifyMAC(dataKey, metadata, m, ret, order); err != nil {
+		return nil, true, err
+	}
+	return ret, true, nil
+}
+
+// verifyMAC compares the MAC of the metadata with the SHA-512 of the decrypted values. Encrypted contains the values
+// as read, to detect which values were encrypted.
+func verifyMAC(dataKey []byte, metadata map[string]interface{}, encrypted, decrypted interface{}, order func(pointer string) (int, int)) error {
+	mac, _ := metadata["mac"].(string)
+	if mac == "" {
+		return ErrNoMAC
+	}
+	lastModified, _ := metadata["lastmodified"].(string)
+	v, err := decryptValue(dataKey, mac, lastModified)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMAC, err)
+	}
+	expected, ok := v.(string)
+	if !ok {
+		return ErrMAC
+	}
+	onlyEncrypted, _ := metadata["mac_only_encrypted"].(bool)
+	h := sha512.New()
+	hashTree(h, encrypted, decrypted, "", order, onlyEncrypted)
+	if subtle.ConstantTimeCompare([]byte(fmt.Sprintf("%X", h.Sum(nil))), []byte(expected)) != 1 {
+		return ErrMAC
+	}
+	return nil
+}
+
+var pointerEscape = strings.NewReplacer("~", "~0", "/", "~1")
+
+// hashTree adds the values below decrypted to h in document order, in the format sops uses.
+func hashTree(h hash.Hash, encrypted, decrypted interface{}, pointer string, order func(pointer string) (int, int), onlyEncrypted bool) {
+	switch t := decrypted.(type) {
+	case map[string]interface{}:
+		enc, _ := encrypted.(map[string]interface{})
+		for _, k := range orderedKeys(t, pointer, order) {
+			hashTree(h, enc[k], t[k], pointer+"/"+pointerEscape.Replace(k), order, onlyEncrypted)
+		}
+	case []interface{}:
+		enc, _ := encrypted.([]interface{})
+		for i, e := range t {
+			var ev interface{}
+			if i < len(enc) {
+				ev = enc[i]
+			}
+			hashTree(h, ev, e, pointer+"/"+strconv.Itoa(i), order, onlyEncrypted)
+		}
+	default:
+		if s, _ := encrypted.(string); onlyEncrypted && !strings.HasPrefix(s, "ENC[") {
+			return
+		}
+		_, _ = h.Write(toBytes(t))
+	}
+}
+
+// orderedKeys returns the keys of m in document order. Keys without position follow in sorted order.
+func orderedKeys(m map[string]interface{}, pointer string, order func(pointer string) (int, int)) []string {
+	type key struct {
+		name         string
+		line, column int
+	}
+	keys := make([]key, 0, len(m))
+	for k := range m {
+		e := key{name: k}
+		if order != nil {
+			e.line, e.column = order(pointer + "/" + pointerEscape.Replace(k))
+		}
+		keys = append(keys, e)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch {
+		case a.line == 0 || b.line == 0:
+			if a.line != b.line {
+				return b.line == 0
+			}
+			return a.name < b.name
+		case a.line != b.line:
+			return a.line < b.line
+		default:
+			return a.column < b.column
+		}
+	})
+	ret := make([]string, len(keys))
+	for i, e := range keys {
+		ret[i] = e.name
+	}
+	return ret
+}
+
+// toBytes returns the representation of a value used by sops to compute the MAC.
+func toBytes(v interface{}) []byte {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(t)
+	case float64:
+		return []byte(strconv.FormatFloat(t, 'f', -1, 64))
+	case bool:
+		if t {
+			return []byte("True")
+		}
+		return []byte("False")
+	default:
+		return []byte(fmt.Sprint(t))
+	}
+}
+
+func (keys *Keys) dataKey(metadata map[string]interface{}) ([]byte, error) {
+	for _, e := range entries(metadata["age"]) {
+		if len(keys.Age) == 0 {
+			break
+		}
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(e)), keys.Age...)
+		if err != nil {
+			continue
+		}
+		return readDataKey(r)
+	}
+	for _, e := range entries(metadata["pgp"]) {
+		if len(keys.PGP) == 0 {
+			break
+		}
+		block, err := pgparmor.Decode(strings.NewReader(e))
+		if err != nil {
+			continue
+		}
+		md, err := openpgp.ReadMessage(block.Body, keys.PGP, func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+			return nil, ErrPassphrase
+		}, nil)
+		if err != nil {
+			continue
+		}
+		return readDataKey(md.UnverifiedBody)
+	}
+	return nil, ErrNoKey
+}
+
+func readDataKey(r io.Reader) ([]byte, error) {
+	key, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != dataKeySize {
+		return nil, ErrMetadata
+	}
+	return key, nil
+}
+
+// entries returns the "enc" fields of a list of key groups.
+func entries(d interface{}) []string {
+	l, _ := d.([]interface{})
+	ret := make([]string, 0, len(l))
+	for _, e := range l {
+		if m, ok := e.(map[string]interface{}); ok {
+			if enc, ok := m["enc"].(string); ok {
+				ret = append(ret, enc)
+			}
+		}
+	}
+	return ret
+}
+
+// decryptTree decrypts all encrypted values below d. Path contains the keys leading to d, array indices are not
+// part of the path.
+func decryptTree(dataKey []byte, d interface{}, path []string) (interface{}, error) {
+	switch t := d.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			var err error
+			if ret[k], err = decryptTree(dataKey, v, append(path[:len(path):len(path)], k)); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i, v := range t {
+			var err error
+			if ret[i], err = decryptTree(dataKey, v, path); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case string:
+		if !strings.HasPrefix(t, "ENC[") {
+			return t, nil
+		}
+		v, err := decryptValue(dataKey, t, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+		return v, nil
+	default:
+		return t, nil
+	}
+}
+
+func decryptValue(dataKey []byte, s, additionalData string) (interface{}, error) {
+	match := encRegex.FindStringSubmatch(s)
+	if match == nil {
+		return nil, ErrValue
+	}
+	data, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil {
+		return nil, ErrValue
+	}
+	iv, err := base64.StdEncoding.DecodeString(match[2])
+	if err != nil || len(iv) != ivSize {
+		return nil, ErrValue
+	}
+	tag, err := base64.StdEncoding.DecodeString(match[3])
+	if err != nil {
+		return nil, ErrValue
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, ivSize)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+	switch match[4] {
+	case "str", "bytes":
+		return string(plain), nil
+	case "int", "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	default:
+		return nil, ErrValueType
+	}
+}
@@ -0,0 +1,186 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func encryptValue(t *testing.T, dataKey []byte, value, valueType, additionalData string) string {
+	iv := make([]byte, ivSize)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatalf("Rand: %s", err)
+	}
+	block, _ := aes.NewCipher(dataKey)
+	gcm, _ := cipher.NewGCMWithNonceSize(block, ivSize)
+	out := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType)
+}
+
+const lastModified = "2024-05-01T10:00:00Z"
+
+// testMAC returns the encrypted MAC of the values in order.
+func testMAC(t *testing.T, dataKey []byte, values string) string {
+	return encryptValue(t, dataKey, fmt.Sprintf("%X", sha512.Sum512([]byte(values))), "str", lastModified)
+}
+
+func testDocument(t *testing.T, dataKey []byte, metadata map[string]interface{}) interface{} {
+	metadata["mac"] = testMAC(t, dataKey, "secret5432adminTrueweb1")
+	metadata["lastmodified"] = lastModified
+	metadata["version"] = "3.8.1"
+	return map[string]interface{}{
+		"db": map[string]interface{}{
+			"password": encryptValue(t, dataKey, "secret", "str", "db:password:"),
+			"port":     encryptValue(t, dataKey, "5432", "int", "db:port:"),
+			"users":    []interface{}{encryptValue(t, dataKey, "admin", "str", "db:users:")},
+		},
+		"debug":                encryptValue(t, dataKey, "True", "bool", "debug:"),
+		"hostname_unencrypted": "web1",
+		MetadataKey:            metadata,
+	}
+}
+
+var expect = map[string]interface{}{
+	"db": map[string]interface{}{
+		"password": "secret",
+		"port":     float64(5432),
+		"users":    []interface{}{"admin"},
+	},
+	"debug":                true,
+	"hostname_unencrypted": "web1",
+}
+
+// ageMetadata returns metadata with dataKey encrypted to a new age identity.
+func ageMetadata(t *testing.T, dataKey []byte) (map[string]interface{}, *age.X25519Identity) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	aw := armor.NewWriter(buf)
+	w, err := age.Encrypt(aw, id.Recipient())
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	_, _ = w.Write(dataKey)
+	_ = w.Close()
+	_ = aw.Close()
+	return map[string]interface{}{
+		"age": []interface{}{map[string]interface{}{"recipient": id.Recipient().String(), "enc": buf.String()}},
+	}, id
+}
+
+func TestDecryptAge(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	_, _ = rand.Read(dataKey)
+	metadata, id := ageMetadata(t, dataKey)
+	doc := testDocument(t, dataKey, metadata)
+	if _, _, err := new(Keys).Decrypt(doc, nil); err != ErrNoKey {
+		t.Errorf("Decrypt without key: %v", err)
+	}
+	keys := &Keys{Age: []age.Identity{id}}
+	d, encrypted, err := keys.Decrypt(doc, nil)
+	if err != nil {
+		t.Fatalf("Decrypt: %s", err)
+	}
+	if !encrypted {
+		t.Error("Not detected as encrypted")
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Errorf("Wrong result: %v", d)
+	}
+	// Values are bound to their path.
+	doc.(map[string]interface{})["moved"] = doc.(map[string]interface{})["debug"]
+	if _, _, err := keys.Decrypt(doc, nil); err == nil {
+		t.Error("Moved value decrypted")
+	}
+}
+
+func TestDecryptPGP(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	_, _ = rand.Read(dataKey)
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatalf("NewEntity: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	aw, _ := pgparmor.Encode(buf, "PGP MESSAGE", nil)
+	w, err := openpgp.Encrypt(aw, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	_, _ = w.Write(dataKey)
+	_ = w.Close()
+	_ = aw.Close()
+	doc := testDocument(t, dataKey, map[string]interface{}{
+		"pgp": []interface{}{map[string]interface{}{"fp": "X", "enc": buf.String()}},
+	})
+	keys := &Keys{PGP: openpgp.EntityList{entity}}
+	d, _, err := keys.Decrypt(doc, nil)
+	if err != nil {
+		t.Fatalf("Decrypt: %s", err)
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Errorf("Wrong result: %v", d)
+	}
+	if d, encrypted, _ := keys.Decrypt(map[string]interface{}{"a": "ENC[x]"}, nil); encrypted || !strings.HasPrefix(d.(map[string]interface{})["a"].(string), "ENC") {
+		t.Error("Unencrypted document modified")
+	}
+}
+
+func TestDecryptMAC(t *testing.T) {
+	dataKey := make([]byte, dataKeySize)
+	_, _ = rand.Read(dataKey)
+	metadata, id := ageMetadata(t, dataKey)
+	keys := &Keys{Age: []age.Identity{id}}
+	doc := testDocument(t, dataKey, metadata)
+	// Document order: hostname_unencrypted first.
+	order := func(pointer string) (int, int) {
+		if pointer == "/hostname_unencrypted" {
+			return 1, 1
+		}
+		return 0, 0
+	}
+	if _, _, err := keys.Decrypt(doc, order); !errors.Is(err, ErrMAC) {
+		t.Errorf("MAC in wrong order accepted: %v", err)
+	}
+	metadata["mac"] = testMAC(t, dataKey, "web1secret5432adminTrue")
+	if _, _, err := keys.Decrypt(doc, order); err != nil {
+		t.Errorf("MAC in document order rejected: %s", err)
+	}
+	doc.(map[string]interface{})["hostname_unencrypted"] = "web2"
+	if _, _, err := keys.Decrypt(doc, order); !errors.Is(err, ErrMAC) {
+		t.Errorf("Modified value accepted: %v", err)
+	}
+	metadata["mac_only_encrypted"] = true
+	metadata["mac"] = testMAC(t, dataKey, "secret5432adminTrue")
+	if _, _, err := keys.Decrypt(doc, nil); err != nil {
+		t.Errorf("MAC of encrypted values rejected: %s", err)
+	}
+	metadata["mac"] = ""
+	if _, _, err := keys.Decrypt(doc, nil); !errors.Is(err, ErrNoMAC) {
+		t.Errorf("Missing MAC accepted: %v", err)
+	}
+	delete(metadata, "mac")
+	if IsEncrypted(doc) {
+		t.Error("Document without MAC detected as encrypted")
+	}
+}
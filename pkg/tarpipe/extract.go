@@ -0,0 +1,129 @@
+package tarpipe
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnsafePath = errors.New("path outside of target directory")
+	ErrEntryType  = errors.New("unsupported entry type")
+)
+
+// Extract writes the content of the tar stream input to dir. Entries must not leave dir through ".." or through
+// symlinks created by the same archive. Existing symlinks in dir are followed, existing files and symlinks at the path
+// of an entry are replaced.
+func Extract(input io.Reader, dir string) error {
+	var links []os.FileInfo // Symlinks created by this extraction.
+	r := tar.NewReader(input)
+	for {
+		header, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		name, err := safePath(dir, header.Name, links)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if isLink(name, links) {
+				return fmt.Errorf("%s: %w", header.Name, ErrUnsafePath)
+			}
+			if err := os.MkdirAll(name, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			if err := removeExisting(name, false); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, r); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			if err := removeExisting(name, true); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, name); err != nil {
+				return err
+			}
+			stat, err := os.Lstat(name)
+			if err != nil {
+				return err
+			}
+			links = append(links, stat)
+		default:
+			return fmt.Errorf("%s: %w", header.Name, ErrEntryType)
+		}
+	}
+}
+
+// removeExisting removes a symlink at name, so that it is replaced instead of followed. If all is true, regular files
+// are removed too.
+func removeExisting(name string, all bool) error {
+	stat, err := os.Lstat(name)
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+	if stat.Mode()&os.ModeSymlink == 0 && !all {
+		return nil
+	}
+	return os.Remove(name)
+}
+
+// isLink returns true if p is one of links.
+func isLink(p string, links []os.FileInfo) bool {
+	stat, err := os.Lstat(p)
+	if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	for _, e := range links {
+		if os.SameFile(stat, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// safePath returns the path of name in dir. It fails if name leaves dir or a parent element is one of links.
+func safePath(dir, name string, links []os.FileInfo) (string, error) {
+	if strings.HasPrefix(filepath.Clean(filepath.FromSlash(name)), "..") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafePath)
+	}
+	clean := filepath.Clean(string(os.PathSeparator) + filepath.FromSlash(name))
+	if clean == string(os.PathSeparator) {
+		return dir, nil
+	}
+	p := dir
+	elements := strings.Split(strings.TrimPrefix(clean, string(os.PathSeparator)), string(os.PathSeparator))
+	for i, e := range elements {
+		p = filepath.Join(p, e)
+		if i < len(elements)-1 && isLink(p, links) {
+			return "", fmt.Errorf("%s: %w", name, ErrUnsafePath)
+		}
+	}
+	return p, nil
+}
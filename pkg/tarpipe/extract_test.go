@@ -0,0 +1,89 @@
+package tarpipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+func makeTar(t *testing.T, entries ...entry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	w := tar.NewWriter(buf)
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     0640,
+			Size:     int64(len(e.content)),
+			Linkname: e.linkname,
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatalf("WriteHeader: %s", err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	return buf
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarpipe")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	in := makeTar(t,
+		entry{name: "etc/", typeflag: tar.TypeDir},
+		entry{name: "etc/app/config.txt", typeflag: tar.TypeReg, content: "data"},
+	)
+	if err := Extract(in, dir); err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	d, err := ioutil.ReadFile(filepath.Join(dir, "etc", "app", "config.txt"))
+	if err != nil || string(d) != "data" {
+		t.Errorf("File not extracted: %s", err)
+	}
+	for _, e := range [][]entry{
+		{{name: "../escape.txt", typeflag: tar.TypeReg}},
+		{{name: "/etc/passwd", typeflag: tar.TypeReg}},
+		{{name: "link", typeflag: tar.TypeSymlink, linkname: "/tmp"}, {name: "link/escape.txt", typeflag: tar.TypeReg}},
+	} {
+		if err := Extract(makeTar(t, e...), dir); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("%s: unsafe path not detected: %v", e[len(e)-1].name, err)
+		}
+	}
+	// Existing symlinks are followed, files and links created before are replaced.
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	if err := os.Symlink("real", filepath.Join(dir, "existing")); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		in = makeTar(t,
+			entry{name: "existing/config.txt", typeflag: tar.TypeReg, content: "data"},
+			entry{name: "existing/current", typeflag: tar.TypeSymlink, linkname: "config.txt"},
+		)
+		if err := Extract(in, dir); err != nil {
+			t.Fatalf("Extract %d: %s", i, err)
+		}
+	}
+	if d, err := ioutil.ReadFile(filepath.Join(dir, "real", "current")); err != nil || string(d) != "data" {
+		t.Errorf("Existing symlink not followed: %s", err)
+	}
+}
@@ -0,0 +1,82 @@
+package tarpipe
+
+import (
+	"github.com/JonathanLogan/cfgtar/pkg/cfgmerge"
+	"strconv"
+	"strings"
+)
+
+// Files that contain secrets are found by rendering them a second time with all secret values changed. If the output
+// differs, it depends on a secret value, also if the value was transformed by the template (upper, b64enc, printf).
+
+// changeSecrets returns a copy of data with the values at the JSON pointers changed. Strings get a different
+// character at each position and grow by one, numbers are incremented and booleans negated. All values below objects
+// and arrays are changed.
+func changeSecrets(data interface{}, pointers []string) interface{} {
+	ret := cfgmerge.Copy(data)
+	for _, p := range pointers {
+		ret = changeAt(ret, pointerPath(p))
+	}
+	return ret
+}
+
+// pointerPath returns the elements of a JSON pointer.
+func pointerPath(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	path := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, e := range path {
+		path[i] = strings.ReplaceAll(strings.ReplaceAll(e, "~1", "/"), "~0", "~")
+	}
+	return path
+}
+
+func changeAt(data interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return changeValue(data)
+	}
+	switch t := data.(type) {
+	case map[string]interface{}:
+		if e, ok := t[path[0]]; ok {
+			t[path[0]] = changeAt(e, path[1:])
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(t) {
+			t[i] = changeAt(t[i], path[1:])
+		}
+	}
+	return data
+}
+
+func changeValue(data interface{}) interface{} {
+	switch t := data.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = changeValue(e)
+		}
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = changeValue(e)
+		}
+		return t
+	case string:
+		b := new(strings.Builder)
+		for _, r := range t {
+			if r == 'x' {
+				b.WriteRune('y')
+			} else {
+				b.WriteRune('x')
+			}
+		}
+		b.WriteRune('x')
+		return b.String()
+	case float64:
+		return t + 1
+	case bool:
+		return !t
+	default:
+		return data
+	}
+}
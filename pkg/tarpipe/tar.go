@@ -3,58 +3,207 @@ package tarpipe
 import (
 	"archive/tar"
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/JonathanLogan/cfgtar/pkg/cfgfile"
 	"github.com/JonathanLogan/cfgtar/pkg/jsonschema"
+	"github.com/JonathanLogan/cfgtar/pkg/redact"
 	"github.com/JonathanLogan/cfgtar/pkg/schemareg"
 	"github.com/JonathanLogan/cfgtar/pkg/tmpfunc"
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"text/template"
 )
 
-func TarPipe(input io.Reader, output io.Writer, reg *schemareg.Registry, delimLeft, delimRight, schemaFileName string) error {
-	var r *tar.Reader
-	var w *tar.Writer
-	r = tar.NewReader(input)
-	if output != nil {
-		w = tar.NewWriter(output)
+// Config contains the settings of TarPipe.
+type Config struct {
+	DelimLeft      string
+	DelimRight     string
+	SchemaFileName string
+	SecretFileMode int64             // Mode of files that contain secret values. Unchanged if 0.
+	SecretFile     func(name string) // Called for each file whose content depends on secret values. Optional.
+	SecretPointers []string          // JSON pointers of the secret values of the config data.
+	// Called for warnings of embedded schemas: keys not defined by the schema outside of strict mode and schemas that
+	// appear after files they apply to. Optional.
+	Warning func(msg string)
+	Strict  bool // Validate with embedded schemas in strict mode.
+	// Matched alternatives of unions and oneOf in the config data by JSON pointer, as returned by validation. The
+	// template function "branch" returns them.
+	Branches map[string]string
+	// Schema the config data was validated with. Embedded schemas with "%extends": true in the top directory extend
+	// it. Optional.
+	Schema interface{}
+	// Loads the schema files Schema refers to, relative names are relative to SchemaFile. Embedded schemas cannot
+	// refer to schema files. Optional.
+	SchemaLoader jsonschema.SchemaLoader
+	SchemaFile   string
+}
+
+// tarEntry is an entry of the input archive.
+type tarEntry struct {
+	header *tar.Header
+	data   []byte
+	dir    []string
+	index  int
+}
+
+// isSchema returns true for embedded schema files.
+func (e *tarEntry) isSchema(config *Config) bool {
+	return e.header.Typeflag == tar.TypeReg && path.Base(e.header.Name) == config.SchemaFileName
+}
+
+// below returns true if the entry is in dir or one of its subdirectories.
+func (e *tarEntry) below(dir []string) bool {
+	if len(e.dir) < len(dir) {
+		return false
 	}
+	for i := range dir {
+		if e.dir[i] != dir[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readEntries reads all entries of the archive. The whole archive is held in memory, since the last schema file can
+// be the last entry and schemas are applied before any entry is rendered.
+func readEntries(r *tar.Reader) ([]*tarEntry, error) {
+	var entries []*tarEntry
 	for {
 		header, err := r.Next()
 		if err != nil {
 			if err == io.EOF {
-				break
+				return entries, nil
 			}
-			return err
+			return nil, err
 		}
 		tempData := new(bytes.Buffer)
 		if _, err := io.Copy(tempData, r); err != nil {
-			return err
+			return nil, err
 		}
+		entries = append(entries, &tarEntry{
+			header: header,
+			data:   tempData.Bytes(),
+			dir:    strings.Split(path.Dir(header.Name), string(os.PathSeparator)),
+			index:  len(entries),
+		})
+	}
+}
 
-		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == schemaFileName {
-			var schema, newData interface{}
-			var pErr []string
-			var err error
-			if err = json.Unmarshal(tempData.Bytes(), &schema); err != nil {
-				return err
+// orderSchemas returns the embedded schemas, parents before children, and warns about schemas that appear after
+// files they apply to.
+func orderSchemas(entries []*tarEntry, config *Config) []*tarEntry {
+	var schemas []*tarEntry
+	for _, e := range entries {
+		if e.isSchema(config) {
+			schemas = append(schemas, e)
+		}
+	}
+	sort.SliceStable(schemas, func(i, j int) bool {
+		return len(schemas[i].dir) < len(schemas[j].dir)
+	})
+	if config.Warning == nil {
+		return schemas
+	}
+	warned := make(map[*tarEntry]bool)
+	for _, e := range entries {
+		if e.isSchema(config) || e.header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Schemas of parent directories apply too, extending schemas build on them.
+		for _, s := range schemas {
+			if e.below(s.dir) && s.index > e.index && !warned[s] {
+				config.Warning(fmt.Sprintf("'%s' appears after '%s' that it applies to", s.header.Name, e.header.Name))
+				warned[s] = true
 			}
-			if pErr, newData, err = jsonschema.Validate(schema, reg.Get(nil)); err != nil {
-				return fmt.Errorf("Validation at '%s': %v %s", header.Name, pErr, err)
+		}
+	}
+	return schemas
+}
+
+// dependsOn returns true if temp renders changed differently than output. Rendering errors count as difference.
+func dependsOn(temp *template.Template, output []byte, changed interface{}) bool {
+	buf := new(bytes.Buffer)
+	if err := temp.Execute(buf, changed); err != nil {
+		return true
+	}
+	return !bytes.Equal(buf.Bytes(), output)
+}
+
+// TarPipe renders the entries of the input archive as templates and writes them to output. Embedded schema files
+// are applied before any entry is rendered, parents before children, so that the result does not depend on the
+// order of the archive. The input archive is read into memory completely.
+func TarPipe(input io.Reader, output io.Writer, reg *schemareg.Registry, config *Config) error {
+	var w *tar.Writer
+	entries, err := readEntries(tar.NewReader(input))
+	if err != nil {
+		return err
+	}
+	if output != nil {
+		w = tar.NewWriter(output)
+	}
+	branches := schemareg.New(config.Branches)
+	schemas := schemareg.New(config.Schema)
+	// Secrets of all schemas, the data of all directories derives from the same config data.
+	secrets := append([]string{}, config.SecretPointers...)
+	for _, e := range orderSchemas(entries, config) {
+		schema, err := cfgfile.Decode(cfgfile.Format(e.header.Name), e.data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.header.Name, err)
+		}
+		if refs := jsonschema.FileRefs(schema); len(refs) > 0 {
+			return fmt.Errorf("%s: %w: %s: embedded schemas cannot refer to schema files", e.header.Name,
+				jsonschema.ErrTypeRef, refs[0])
+		}
+		if jsonschema.Extends(schema) {
+			// The schema of dir is not registered yet, Get returns the schema of the closest parent.
+			schema = jsonschema.Extend(schemas.Get(e.dir), schema)
+		}
+		// References to schema files are those of Schema.
+		result := jsonschema.ValidateAll(schema, reg.Get(nil), jsonschema.Strict(config.Strict),
+			jsonschema.Loader(config.SchemaLoader), jsonschema.SchemaFile(config.SchemaFile))
+		redact.Add(result.Secrets...)
+		secrets = append(secrets, result.SecretPointers...)
+		if config.Warning != nil {
+			for _, w := range result.Warnings {
+				config.Warning(fmt.Sprintf("'%s': %s", e.header.Name, w))
 			}
-			reg.Add(strings.Split(path.Dir(header.Name), string(os.PathSeparator)), newData)
+		}
+		if len(result.Violations) > 0 {
+			return fmt.Errorf("Validation at '%s':\n%s", e.header.Name, result.Violations)
+		}
+		reg.Add(e.dir, result.Data)
+		branches.Add(e.dir, result.Branches)
+		schemas.Add(e.dir, schema)
+	}
+	changed := make(map[string]interface{}) // Data with changed secrets by directory.
+	changedData := func(dir []string) interface{} {
+		key := strings.Join(dir, "/")
+		if _, ok := changed[key]; !ok {
+			changed[key] = changeSecrets(reg.Get(dir), secrets)
+		}
+		return changed[key]
+	}
+	for _, e := range entries {
+		if e.isSchema(config) {
 			continue
 		}
-		data := reg.Get(strings.Split(path.Dir(header.Name), string(os.PathSeparator)))
+		header := e.header
+		data := reg.Get(e.dir)
+		dirBranches, _ := branches.Get(e.dir).(map[string]string)
 
 		temp := template.New("")
 		temp.Option("missingkey=error")
 		temp.Funcs(tmpfunc.FuncMap)
-		temp = temp.Delims(delimLeft, delimRight)
-		temp, errT := temp.Parse(tempData.String())
+		temp.Funcs(template.FuncMap{
+			"branch": func(pointer string) string {
+				return dirBranches[pointer]
+			},
+		})
+		temp = temp.Delims(config.DelimLeft, config.DelimRight)
+		temp, errT := temp.Parse(string(e.data))
 		if errT != nil {
 			return errT
 		}
@@ -62,6 +211,14 @@ func TarPipe(input io.Reader, output io.Writer, reg *schemareg.Registry, delimLe
 		if err := temp.Execute(buf, data); err != nil {
 			return err
 		}
+		if header.Typeflag == tar.TypeReg && len(secrets) > 0 && dependsOn(temp, buf.Bytes(), changedData(e.dir)) {
+			if config.SecretFile != nil {
+				config.SecretFile(header.Name)
+			}
+			if config.SecretFileMode != 0 {
+				header.Mode = config.SecretFileMode
+			}
+		}
 		if w != nil {
 			header.Size = int64(buf.Len())
 			if err := w.WriteHeader(header); err != nil {
@@ -0,0 +1,211 @@
+package tarpipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"github.com/JonathanLogan/cfgtar/pkg/jsonschema"
+	"github.com/JonathanLogan/cfgtar/pkg/schemareg"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readTar returns the content of the regular files in d by name.
+func readTar(t *testing.T, d *bytes.Buffer) map[string]string {
+	ret := make(map[string]string)
+	r := tar.NewReader(d)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return ret
+		}
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		b := new(strings.Builder)
+		if _, err := io.Copy(b, r); err != nil {
+			t.Fatalf("Read: %s", err)
+		}
+		ret[h.Name] = b.String()
+	}
+}
+
+func TestTarPipeExtends(t *testing.T) {
+	config := map[string]interface{}{"name": "web", "port": float64(8080)}
+	schema := map[string]interface{}{"name": "string", "port": "int%default=80", "mode": "string%default=fast"}
+	in := makeTar(t,
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"%extends": true, "port": "int(min=1024)%required"}`},
+		entry{name: "a/x.txt", typeflag: tar.TypeReg, content: `{{.name}}:{{.port}}:{{.mode}}`},
+		entry{name: "b/._schema.json", typeflag: tar.TypeReg, content: `{"port": "int"}`},
+		entry{name: "b/x.txt", typeflag: tar.TypeReg, content: `{{.port}}`},
+	)
+	out := new(bytes.Buffer)
+	err := TarPipe(in, out, schemareg.New(config), &Config{
+		DelimLeft:      "{{",
+		DelimRight:     "}}",
+		SchemaFileName: "._schema.json",
+		Schema:         schema,
+	})
+	if err != nil {
+		t.Fatalf("TarPipe: %s", err)
+	}
+	files := readTar(t, out)
+	if files["a/x.txt"] != "web:8080:fast" || files["b/x.txt"] != "8080" {
+		t.Errorf("Wrong output: %v", files)
+	}
+	config["port"] = float64(80)
+	in = makeTar(t,
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"%extends": true, "port": "int(min=1024)"}`},
+	)
+	err = TarPipe(in, nil, schemareg.New(config), &Config{SchemaFileName: "._schema.json", Schema: schema})
+	if err == nil || !strings.Contains(err.Error(), "port: 80 is below min=1024") {
+		t.Errorf("Extending schema not applied: %v", err)
+	}
+}
+
+func TestTarPipeSchemaRefs(t *testing.T) {
+	config := map[string]interface{}{"name": "webserver", "port": float64(8080)}
+	schema := map[string]interface{}{"name": "types.json#name", "port": "int"}
+	var loads []string
+	tarConfig := &Config{
+		SchemaFileName: "._schema.json",
+		Schema:         schema,
+		SchemaFile:     "schemas/main.json",
+		SchemaLoader: func(name string) (interface{}, error) {
+			loads = append(loads, name)
+			return map[string]interface{}{"%types": map[string]interface{}{"name": "string(max=3)"}}, nil
+		},
+	}
+	// Extending schemas use the schema files of Schema.
+	in := makeTar(t,
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"%extends": true, "port": "int(min=1024)"}`},
+	)
+	err := TarPipe(in, nil, schemareg.New(config), tarConfig)
+	if err == nil || !strings.Contains(err.Error(), "name: length 9 is above max=3") ||
+		len(loads) != 1 || loads[0] != "schemas/types.json" {
+		t.Errorf("Schema file not applied: %v %v", err, loads)
+	}
+	in = makeTar(t,
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"port": "types.json#port"}`},
+	)
+	err = TarPipe(in, nil, schemareg.New(config), tarConfig)
+	if !errors.Is(err, jsonschema.ErrTypeRef) {
+		t.Errorf("Reference of embedded schema accepted: %v", err)
+	}
+}
+
+func TestTarPipeOrder(t *testing.T) {
+	config := map[string]interface{}{"name": "web"}
+	in := makeTar(t,
+		entry{name: "a/b/x.txt", typeflag: tar.TypeReg, content: `{{.name}}:{{.port}}`},
+		entry{name: "a/b/._schema.json", typeflag: tar.TypeReg, content: `{"%extends": true, "port": "int%default=443"}`},
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"name": "string(max=3)%default=x"}`},
+		entry{name: "a/y.txt", typeflag: tar.TypeReg, content: `{{.name}}`},
+		entry{name: "c/z.txt", typeflag: tar.TypeReg, content: `{{.name}}`},
+	)
+	out := new(bytes.Buffer)
+	var warnings []string
+	err := TarPipe(in, out, schemareg.New(config), &Config{
+		DelimLeft:      "{{",
+		DelimRight:     "}}",
+		SchemaFileName: "._schema.json",
+		Warning: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+	})
+	if err != nil {
+		t.Fatalf("TarPipe: %s", err)
+	}
+	files := readTar(t, out)
+	if len(files) != 3 || files["a/b/x.txt"] != "web:443" || files["a/y.txt"] != "web" || files["c/z.txt"] != "web" {
+		t.Errorf("Wrong output: %v", files)
+	}
+	// The schema of a/ applies to the files below a/ too.
+	expect := []string{
+		"'a/._schema.json' appears after 'a/b/x.txt' that it applies to",
+		"'a/b/._schema.json' appears after 'a/b/x.txt' that it applies to",
+	}
+	if strings.Join(warnings, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Wrong warnings: %q", warnings)
+	}
+}
+
+func TestTarPipeValidatedData(t *testing.T) {
+	config := map[string]interface{}{"mem": float64(2000000), "t": "90s"}
+	in := makeTar(t,
+		entry{name: "a/._schema.json", typeflag: tar.TypeReg,
+			content: `{"mem": "int", "t": "duration", "wait": "duration%default=2m"}`},
+		entry{name: "a/x.txt", typeflag: tar.TypeReg, content: `mem={{.mem}} t={{.t}} wait={{.wait}}`},
+	)
+	out := new(bytes.Buffer)
+	err := TarPipe(in, out, schemareg.New(config), &Config{DelimLeft: "{{", DelimRight: "}}", SchemaFileName: "._schema.json"})
+	if err != nil {
+		t.Fatalf("TarPipe: %s", err)
+	}
+	// Templates below embedded schemas see the converted values.
+	if files := readTar(t, out); files["a/x.txt"] != "mem=2000000 t=1m30s wait=2m0s" {
+		t.Errorf("Wrong output: %v", files)
+	}
+}
+
+func TestTarPipeSchemaFormat(t *testing.T) {
+	config := map[string]interface{}{"port": float64(80)}
+	in := makeTar(t,
+		entry{name: "a/._schema.yaml", typeflag: tar.TypeReg, content: "port: int(min=1024)\n"},
+	)
+	err := TarPipe(in, nil, schemareg.New(config), &Config{SchemaFileName: "._schema.yaml"})
+	if err == nil || !strings.Contains(err.Error(), "port: 80 is below min=1024") {
+		t.Errorf("YAML schema not applied: %v", err)
+	}
+}
+
+func TestTarPipeSecrets(t *testing.T) {
+	config := map[string]interface{}{"password": "ab", "port": float64(8080)}
+	in := makeTar(t,
+		entry{name: "prefix.txt", typeflag: tar.TypeReg, content: `{{printf "%.1s" .password}}`},
+		entry{name: "length.txt", typeflag: tar.TypeReg, content: `{{len .password}}`},
+		entry{name: "port.txt", typeflag: tar.TypeReg, content: `tab {{.port}}`},
+		entry{name: "set.txt", typeflag: tar.TypeReg, content: `{{if .password}}set{{end}}`},
+	)
+	secretFiles := make(map[string]bool)
+	err := TarPipe(in, new(bytes.Buffer), schemareg.New(config), &Config{
+		DelimLeft:      "{{",
+		DelimRight:     "}}",
+		SecretFile:     func(name string) { secretFiles[name] = true },
+		SecretPointers: []string{"/password"},
+	})
+	if err != nil {
+		t.Fatalf("TarPipe: %s", err)
+	}
+	if !secretFiles["prefix.txt"] || !secretFiles["length.txt"] || len(secretFiles) != 2 {
+		t.Errorf("Wrong secret files: %v", secretFiles)
+	}
+	if config["password"] != "ab" {
+		t.Error("Config data modified")
+	}
+}
+
+func TestTarPipeWarnings(t *testing.T) {
+	config := map[string]interface{}{"port": float64(80), "extra": "x"}
+	newInput := func() io.Reader {
+		return makeTar(t, entry{name: "a/._schema.json", typeflag: tar.TypeReg, content: `{"port": "int"}`})
+	}
+	var warnings []string
+	err := TarPipe(newInput(), nil, schemareg.New(config), &Config{
+		SchemaFileName: "._schema.json",
+		Warning: func(msg string) {
+			warnings = append(warnings, msg)
+		},
+	})
+	if err != nil {
+		t.Fatalf("TarPipe: %s", err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "'a/._schema.json': extra: ") {
+		t.Errorf("Wrong warnings: %q", warnings)
+	}
+	err = TarPipe(newInput(), nil, schemareg.New(config), &Config{SchemaFileName: "._schema.json", Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "extra: ") {
+		t.Errorf("Unknown key accepted in strict mode: %v", err)
+	}
+}
@@ -1,6 +1,27 @@
 package tmpfunc
 
-import "net"
+import (
+	"context"
+	"errors"
+	"github.com/JonathanLogan/cfgtar/pkg/dnsquery"
+	"net"
+	"strings"
+)
+
+// dnsResolver are the lookups of net.Resolver used by the template functions.
+type dnsResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
+
+// resolver and lookupCAA are replaced by tests.
+var (
+	resolver  dnsResolver = net.DefaultResolver
+	lookupCAA             = dnsquery.LookupCAA
+)
 
 func ipLookup(ver int, s string) ([]string, error) {
 	addr, err := net.LookupIP(s)
@@ -30,3 +51,97 @@ func ipv6lookup(s string) ([]string, error) {
 func dnsTXT(s string) ([]string, error) {
 	return net.LookupTXT(s)
 }
+
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+type MX struct {
+	Host string
+	Pref uint16
+}
+
+func trimDot(s string) string {
+	return strings.TrimSuffix(s, ".")
+}
+
+// dnsSRV looks up SRV records. Either the full name (_service._proto.name) or service, proto and name are given.
+func dnsSRV(s ...string) ([]SRV, error) {
+	var service, proto, name string
+	switch len(s) {
+	case 1:
+		name = s[0]
+	case 3:
+		service, proto, name = s[0], s[1], s[2]
+	default:
+		return nil, errors.New("dnsSRV requires name or service proto name")
+	}
+	_, addrs, err := resolver.LookupSRV(context.Background(), service, proto, name)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]SRV, 0, len(addrs))
+	for _, a := range addrs {
+		ret = append(ret, SRV{
+			Target:   trimDot(a.Target),
+			Port:     a.Port,
+			Priority: a.Priority,
+			Weight:   a.Weight,
+		})
+	}
+	return ret, nil
+}
+
+func dnsMX(s string) ([]MX, error) {
+	addrs, err := resolver.LookupMX(context.Background(), s)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]MX, 0, len(addrs))
+	for _, a := range addrs {
+		ret = append(ret, MX{
+			Host: trimDot(a.Host),
+			Pref: a.Pref,
+		})
+	}
+	return ret, nil
+}
+
+func dnsCNAME(s string) (string, error) {
+	cname, err := resolver.LookupCNAME(context.Background(), s)
+	if err != nil {
+		return "", err
+	}
+	return trimDot(cname), nil
+}
+
+func dnsPTR(s string) ([]string, error) {
+	names, err := resolver.LookupAddr(context.Background(), s)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(names))
+	for _, n := range names {
+		ret = append(ret, trimDot(n))
+	}
+	return ret, nil
+}
+
+func dnsNS(s string) ([]string, error) {
+	ns, err := resolver.LookupNS(context.Background(), s)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(ns))
+	for _, n := range ns {
+		ret = append(ret, trimDot(n.Host))
+	}
+	return ret, nil
+}
+
+func dnsCAA(s string) ([]dnsquery.CAA, error) {
+	return lookupCAA(s)
+}
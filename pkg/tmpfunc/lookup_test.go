@@ -0,0 +1,78 @@
+package tmpfunc
+
+import (
+	"context"
+	"github.com/JonathanLogan/cfgtar/pkg/dnsquery"
+	"net"
+	"testing"
+)
+
+// stubResolver returns fixed records for example.com and 192.0.2.1.
+type stubResolver struct{}
+
+func (stubResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	if name != "_ldap._tcp.example.com" {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, []*net.SRV{
+		{Target: "ldap1.example.com.", Port: 389, Priority: 10, Weight: 60},
+		{Target: "ldap2.example.com.", Port: 636, Priority: 20, Weight: 40},
+	}, nil
+}
+
+func (stubResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	return []*net.MX{{Host: "mx." + name + ".", Pref: 10}}, nil
+}
+
+func (stubResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	return "web." + host + ".", nil
+}
+
+func (stubResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if addr != "192.0.2.1" {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return []string{"host.example.com."}, nil
+}
+
+func (stubResolver) LookupNS(_ context.Context, name string) ([]*net.NS, error) {
+	return []*net.NS{{Host: "ns1." + name + "."}, {Host: "ns2." + name + "."}}, nil
+}
+
+func TestDNSLookup(t *testing.T) {
+	defer func(r dnsResolver, caa func(string) ([]dnsquery.CAA, error)) { resolver, lookupCAA = r, caa }(resolver, lookupCAA)
+	resolver = stubResolver{}
+	lookupCAA = func(name string) ([]dnsquery.CAA, error) {
+		return []dnsquery.CAA{{Tag: "issue", Value: "ca." + name}}, nil
+	}
+	td := map[string]interface{}{"domain": "example.com"}
+	for _, test := range []struct {
+		templ, expect string
+	}{
+		{`{{range dnsSRV "_ldap._tcp.example.com"}}{{.Target}}:{{.Port}}/{{.Priority}}/{{.Weight}} {{end}}`,
+			"ldap1.example.com:389/10/60 ldap2.example.com:636/20/40 "},
+		{`{{range dnsSRV "ldap" "tcp" .domain}}{{.Target}} {{end}}`, "ldap1.example.com ldap2.example.com "},
+		{`{{range dnsMX .domain}}{{.Host}} {{.Pref}}{{end}}`, "mx.example.com 10"},
+		{`{{dnsCNAME .domain}}`, "web.example.com"},
+		{`{{dnsPTR "192.0.2.1"}}`, "[host.example.com]"},
+		{`{{dnsNS .domain}}`, "[ns1.example.com ns2.example.com]"},
+		{`{{range dnsCAA .domain}}{{.Tag}} {{.Value}}{{end}}`, "issue ca.example.com"},
+	} {
+		out, err := executeTemplate(test.templ, FuncMap, td)
+		if err != nil {
+			t.Errorf("%s: %s", test.templ, err)
+			continue
+		}
+		if out != test.expect {
+			t.Errorf("%s: %q", test.templ, out)
+		}
+	}
+	for _, templ := range []string{`{{dnsSRV "_x._tcp.example.com"}}`, `{{dnsPTR "192.0.2.2"}}`, `{{dnsSRV "a" "b"}}`} {
+		if _, err := executeTemplate(templ, FuncMap, td); err == nil {
+			t.Errorf("%s: error not returned", templ)
+		}
+	}
+}
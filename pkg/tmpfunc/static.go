@@ -27,6 +27,12 @@ var FuncMap = template.FuncMap{
 	"ipv4lookup":  ipv4lookup,
 	"ipv6lookup":  ipv6lookup,
 	"dnsTXT":      dnsTXT,
+	"dnsSRV":      dnsSRV,
+	"dnsMX":       dnsMX,
+	"dnsCNAME":    dnsCNAME,
+	"dnsPTR":      dnsPTR,
+	"dnsNS":       dnsNS,
+	"dnsCAA":      dnsCAA,
 	"ipv4addrRel": ipv4addrRel,
 	"ipv6addrRel": ipv6addrRel,
 }